@@ -2,168 +2,239 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"calc/models"
+	"calc/operations"
+	"calc/taskrpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
-var taskMap = make(map[string]models.Task) // Мапа для хранения задач, ключ - ID задачи
-var mu sync.Mutex // Мьютекс для синхронизации доступа к taskMap
-
-// Функция для получения результата задачи
-func getTaskResult(taskId string) (float64, error) {
-	// Проверка, существует ли задача в мапе
-	mu.Lock()
-	task, exists := taskMap[taskId]
-	mu.Unlock()
-	if !exists {
-		return 0, fmt.Errorf("задача с ID %s не найдена", taskId)
-	}
-
-	// Ждем, пока задача не будет выполнена
-	for !task.Status {
-		// log.Printf("задача %s еще не выполнена. Ожидание...", taskId)
-		time.Sleep(1 * time.Second) // Ждем 1 секунду перед повторной проверкой
-		mu.Lock()
-		task, exists = taskMap[taskId] // Повторно получаем задачу из мапы
-		mu.Unlock()
-		if !exists {
-			return 0, fmt.Errorf("задача с ID %s не найдена", taskId)
+// Отправляем результаты (или ошибки) задач оркестратору одним запросом
+func sendResults(client *http.Client, url string, results []models.Responce2) {
+	data, err := json.Marshal(models.ResultBatch{Results: results})
+	if err != nil {
+		// Один непередаваемый результат не должен потерять остальные
+		for i := range results {
+			results[i] = encodableResult(results[i])
+		}
+		if data, err = json.Marshal(models.ResultBatch{Results: results}); err != nil {
+			log.Printf("ошибка при кодировании результатов: %v", err)
+			return
 		}
 	}
 
-	// Возвращаем результат задачи, если она выполнена
-	return task.Result, nil
-}
+	res, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("ошибка при отправке результатов: %v", err)
+		return
+	}
+	defer res.Body.Close()
 
-// Функция для выполнения операции (например, сложение, вычитание)
-func PerformOperation(task *models.Task) float64 {
-	mu.Lock()
-	defer mu.Unlock()
-
-	switch task.Operation {
-	case "+":
-		task.Result = task.Arg1 + task.Arg2
-	case "-":
-		task.Result = task.Arg1 - task.Arg2
-	case "*":
-		task.Result = task.Arg1 * task.Arg2
-	case "/":
-		if task.Arg2 != 0 {
-			task.Result = task.Arg1 / task.Arg2
-		} else {
-			// log.Println("деление на ноль")
-			task.Result = 0
+	// Оркестратор сообщает, какие результаты не приняты, например если
+	// аренда задачи истекла и задачу уже посчитал другой агент
+	var response struct {
+		Results []models.ResultStatus `json:"results"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return
+	}
+	for _, status := range response.Results {
+		if status.Status != "accepted" {
+			log.Printf("результат задачи %s не принят: %s", status.Id, status.Error)
 		}
-	default:
-		// log.Println("неизвестная операция")
-		task.Result = 0
 	}
+}
+
+// Результат, который нельзя передать в JSON (бесконечность или NaN),
+// заменяем ошибкой задачи, чтобы оркестратор завершил выражение с ошибкой
+func encodableResult(result models.Responce2) models.Responce2 {
+	if _, err := json.Marshal(result); err != nil {
+		log.Printf("результат задачи %s нельзя отправить: %v", result.Id, err)
+		return models.Responce2{Id: result.Id, ErrorCode: models.ErrOverflow, Error: "результат вне допустимого диапазона"}
+	}
+	return result
+}
+
+// Продлеваем аренду задачи, пока она считается. Интервал - треть
+// оставшегося срока аренды; закрытие done останавливает продление.
+// Если оркестратор отвечает, что задача больше не за агентом
+// (например, выражение отменено), ставим dropped
+func keepLease(client *http.Client, url string, task *models.Task, done <-chan struct{}, dropped *atomic.Bool) {
+	deadline := task.LeaseDeadline
+	for {
+		interval := time.Until(deadline) / 3
+		if interval < 50*time.Millisecond {
+			interval = 50 * time.Millisecond
+		}
 
-	// Обновляем статус задачи на выполненную
-	task.Status = true
-	taskMap[task.Id] = *task // Обновляем задачу в мапе
-	// log.Printf("Задача %s выполнена. Результат: %f", task.Id, task.Result)
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+		}
 
-	return task.Result
+		res, err := client.Post(url+"/"+task.Id+"/heartbeat", "application/json", nil)
+		if err != nil {
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			// Задача больше не за нами: результат оркестратор уже не ждёт
+			res.Body.Close()
+			if res.StatusCode == http.StatusNotFound {
+				dropped.Store(true)
+			}
+			return
+		}
+		var lease models.LeaseResponse
+		if err := json.NewDecoder(res.Body).Decode(&lease); err == nil {
+			deadline = lease.LeaseDeadline
+		}
+		res.Body.Close()
+	}
 }
 
+// Сколько оркестратор держит запрос задачи, если очередь пуста
+const taskWait = 30 * time.Second
 
-// Функция для воркера
-func worker(id int, pollInterval time.Duration, wg *sync.WaitGroup) {
-	defer wg.Done() // Уменьшаем счётчик после завершения работы горутины
+// Сколько ждём другие готовые результаты, чтобы отправить их одним запросом
+const resultFlushInterval = 10 * time.Millisecond
 
+// Пул воркеров агента. Задачи запрашиваются по мере освобождения воркеров:
+// в запросе max - число свободных воркеров, и каждая полученная задача
+// сразу начинает считаться. Результаты отправляются по мере готовности,
+// не дожидаясь остальных задач того же запроса
+func worker(agentID string, workers int, pollInterval time.Duration) {
 	client := &http.Client{Timeout: 5 * time.Second}
 	orchestratorURL := "http://localhost:8080/internal/task"
 
+	// Задачу ждём на стороне оркестратора (long-polling), поэтому
+	// таймаут клиента для запроса задачи больше времени ожидания
+	pollClient := &http.Client{Timeout: taskWait + 5*time.Second}
+	pollURL := fmt.Sprintf("%s?agent_id=%s&wait=%d",
+		orchestratorURL, url.QueryEscape(agentID), int(taskWait.Seconds()))
+
+	// Номера свободных воркеров
+	idle := make(chan int, workers)
+	for i := 1; i <= workers; i++ {
+		idle <- i
+	}
+
+	results := make(chan models.Responce2, workers)
+	go resultSender(client, orchestratorURL, results, workers)
+
 	for {
-		resp, err := client.Get(orchestratorURL)
-		if err != nil {
-			// log.Printf("[Worker %d] ошибка при получении задачи: %v", id, err)
-			time.Sleep(pollInterval)
-			continue
-		}
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			time.Sleep(pollInterval)
-			continue
+		// Ждём хотя бы одного свободного воркера и забираем всех остальных свободных
+		free := []int{<-idle}
+	drain:
+		for len(free) < workers {
+			select {
+			case id := <-idle:
+				free = append(free, id)
+			default:
+				break drain
+			}
 		}
 
-		var task models.Task
-		if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
-			// log.Printf("[Worker %d] ошибка при декодировании задачи: %v", id, err)
-			resp.Body.Close()
-			time.Sleep(pollInterval)
-			continue
-		}
-		resp.Body.Close()
-
-		// Добавляем задачу в мапу (или обновляем её, если она уже есть)
-		mu.Lock()
-		taskMap[task.Id] = task
-		mu.Unlock()
-
-		// Проверяем зависимости и ждём, пока они не будут выполнены
-		for _, depId := range task.Dependencies {
-			// Получаем результат зависимости
-			for {
-				result, err := getTaskResult(depId)
-				if err != nil {
-					// log.Printf("[Worker %d] ошибка при получении результата зависимости %s: %v", id, depId, err)
-					time.Sleep(pollInterval) // Ждём, если зависимость ещё не выполнена
-					continue
-				}
-				// log.Printf("[Worker %d] Зависимость %s выполнена с результатом %f", id, depId, result)
+		tasks := fetchTasks(pollClient, fmt.Sprintf("%s&max=%d", pollURL, len(free)), pollInterval)
 
-				// Устанавливаем аргумент из зависимости
-				if task.Arg1 == 0 {
-					task.Arg1 = result
-				} else {
-					task.Arg2 = result
-				}
+		// Оркестратор выдаёт задачу только когда её зависимости выполнены
+		// и их результаты уже подставлены в аргументы
+		for i, task := range tasks {
+			go func(workerID int) {
+				done := make(chan struct{})
+				var dropped atomic.Bool
+				go keepLease(client, orchestratorURL, task, done, &dropped)
+				result := performTask(workerID, task)
+				close(done)
 
-				// Все зависимости выполнены
-				break
-			}
+				// Результат задачи, которую оркестратор уже отменил, не отправляем
+				if !dropped.Load() {
+					results <- result
+				}
+				idle <- workerID
+			}(free[i])
 		}
+		for _, id := range free[len(tasks):] {
+			idle <- id
+		}
+	}
+}
 
-		// log.Printf("[Worker %d] Выполнение задачи %s", id, task.Id)
-		// Выполняем операцию
-		result := PerformOperation(&task)
+// Запрашиваем задачи; при ошибке ждём pollInterval и возвращаем пустой список
+func fetchTasks(client *http.Client, pollURL string, pollInterval time.Duration) []*models.Task {
+	resp, err := client.Get(pollURL)
+	if err != nil {
+		// log.Printf("ошибка при получении задач: %v", err)
+		time.Sleep(pollInterval)
+		return nil
+	}
+	defer resp.Body.Close()
 
-		// log.Printf("[Worker %d] Результат задачи %s: %f", id, task.Id, result)
+	if resp.StatusCode == http.StatusNotFound {
+		// За время ожидания задач не появилось, сразу ждём снова
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		time.Sleep(pollInterval)
+		return nil
+	}
 
-		// Отправляем результат только если задача финальная
-		if task.IsFinal {
-			payload := models.Responce2{
-				Id:     task.ExpressionID,
-				Result: result,
-			}
-			data, _ := json.Marshal(payload)
+	var batch models.TaskBatch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		// log.Printf("ошибка при декодировании задач: %v", err)
+		time.Sleep(pollInterval)
+		return nil
+	}
+	return batch.Tasks
+}
 
-			res, err := client.Post(orchestratorURL, "application/json", bytes.NewReader(data))
-			if err != nil {
-				// log.Printf("[Worker %d] ошибка при отправке финального результата: %v", id, err)
-				continue
+// Отправляем результаты по мере готовности: первый результат ждёт
+// остальные не дольше resultFlushInterval, затем все уходят одним запросом
+func resultSender(client *http.Client, url string, results <-chan models.Responce2, limit int) {
+	for first := range results {
+		batch := []models.Responce2{first}
+		timer := time.NewTimer(resultFlushInterval)
+	collect:
+		for len(batch) < limit {
+			select {
+			case result := <-results:
+				batch = append(batch, result)
+			case <-timer.C:
+				break collect
 			}
-			res.Body.Close()
-			// log.Printf("[Worker %d] Финальный результат задачи %s отправлен", id, task.Id)
-
-			// Вывод финального результата в консоль
-			// fmt.Printf("ФИНАЛЬНЫЙ РЕЗУЛЬТАТ (%s): %.2f\n", task.ExpressionID, result)
 		}
+		timer.Stop()
+		sendResults(client, url, batch)
 	}
 }
 
+// Выполняем задачу и готовим результат для оркестратора
+func performTask(workerID int, task *models.Task) models.Responce2 {
+	result := operations.Perform(task)
+	if result.ErrorCode != "" {
+		log.Printf("[Worker %d] задача %s завершилась ошибкой: %s", workerID, task.Id, result.Error)
+	}
+	return result
+}
 
 func main() {
+	transport := flag.String("transport", "http", "транспорт до оркестратора: http или grpc")
+	grpcAddr := flag.String("grpc-addr", "localhost:9090", "адрес gRPC-сервера оркестратора")
+	flag.Parse()
+
 	// Задать переменную окружения внутри программы
 	os.Setenv("COMPUTING_POWER", "2") // Тут можно поставить любое значение
 
@@ -174,17 +245,53 @@ func main() {
 		}
 	}
 
-	log.Printf("Агент запущен с %d воркерами", computingPower)
+	// ID агента можно задать через AGENT_ID, иначе его выдаст оркестратор
+	reg := &models.AgentRegistration{
+		Id:             os.Getenv("AGENT_ID"),
+		ComputingPower: computingPower,
+		Version:        agentVersion,
+		Operations:     operations.Supported,
+	}
+
+	switch *transport {
+	case "http":
+		runHTTP(reg)
+	case "grpc":
+		runGRPC(*grpcAddr, reg)
+	default:
+		log.Fatalf("неизвестный транспорт %q", *transport)
+	}
+}
+
+// Задачи запрашиваются через /internal/task
+func runHTTP(reg *models.AgentRegistration) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	agentsURL := "http://localhost:8080/internal/agents"
+	agentID := registerAgent(client, agentsURL, reg)
+	go agentHeartbeat(client, agentsURL, reg)
+
+	log.Printf("Агент %s запущен с %d воркерами", agentID, reg.ComputingPower)
 
-	// Создаём объект sync.WaitGroup для ожидания завершения всех горутин
-	var wg sync.WaitGroup
+	// Задач берём столько, сколько воркеров, и считаем их параллельно
+	worker(agentID, reg.ComputingPower, 500*time.Millisecond)
+}
 
-	// Запуск воркеров
-	for i := 0; i < computingPower; i++ {
-		wg.Add(1) // Увеличиваем счётчик горутин
-		go worker(i+1, 500*time.Millisecond, &wg)
+// Задачи и результаты идут через один gRPC-поток; при обрыве
+// агент переподключается с тем же ID
+func runGRPC(addr string, reg *models.AgentRegistration) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal("ошибка подключения к оркестратору:", err)
 	}
+	defer conn.Close()
 
-	// Ожидаем завершения всех горутин
-	wg.Wait()
+	log.Printf("Агент запущен с %d воркерами (gRPC, %s)", reg.ComputingPower, addr)
+	perform := func(task *models.Task) models.Responce2 {
+		return performTask(0, task)
+	}
+	for {
+		err := taskrpc.RunAgent(context.Background(), conn, reg, perform)
+		log.Printf("gRPC-поток агента %s закрыт: %v", reg.Id, err)
+		time.Sleep(time.Second)
+	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"calc/models"
+)
+
+// Версия агента, которую он сообщает оркестратору при регистрации
+const agentVersion = "1.0.0"
+
+// Интервал, с которым агент сообщает оркестратору, что он на связи
+const agentHeartbeatInterval = 5 * time.Second
+
+// Регистрируемся у оркестратора, пока он не ответит, и возвращаем ID агента
+func registerAgent(client *http.Client, baseURL string, reg *models.AgentRegistration) string {
+	for {
+		data, _ := json.Marshal(reg)
+		res, err := client.Post(baseURL+"/register", "application/json", bytes.NewReader(data))
+		if err == nil {
+			var resp models.Responce1
+			decodeErr := json.NewDecoder(res.Body).Decode(&resp)
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK && decodeErr == nil {
+				reg.Id = resp.Id
+				return resp.Id
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// Периодически сообщаем оркестратору, что агент на связи. Если оркестратор
+// не знает агента (например, после перезапуска), регистрируемся заново с тем же ID
+func agentHeartbeat(client *http.Client, baseURL string, reg *models.AgentRegistration) {
+	for range time.Tick(agentHeartbeatInterval) {
+		res, err := client.Post(baseURL+"/"+url.PathEscape(reg.Id)+"/heartbeat", "application/json", nil)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusNotFound {
+			log.Printf("Оркестратор не знает агента %s, повторная регистрация", reg.Id)
+			registerAgent(client, baseURL, reg)
+		}
+	}
+}
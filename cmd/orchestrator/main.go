@@ -7,12 +7,17 @@ import (
 	"calc/orchestrator"
 	"calc/database"
 	"fmt"
+	"calc/models"
+	"os"
 )
 
 func main() {
 
 	r := chi.NewRouter()
 
+	// Время операций можно задать через переменные окружения
+	models.LoadOperationTimes()
+
 
 	// Инициализация базы данных
 	userDB, expressionDB, err := database.InitDB()
@@ -25,6 +30,20 @@ func main() {
 	fmt.Println("База данных пользователей успешно инициализирована")
 	fmt.Println("База данных выражений успешно инициализирована")
 
+	// Хранилище задач: после перезапуска продолжаем недосчитанные выражения
+	taskDB, err := database.InitTaskDB()
+	if err != nil {
+		log.Fatal("ошибка при инициализации хранилища задач:", err)
+	}
+	defer taskDB.Close()
+
+	if err := orchestrator.InitTaskStore(taskDB, expressionDB); err != nil {
+		log.Fatal("ошибка при восстановлении задач:", err)
+	}
+
+	// Задачи с истёкшей арендой возвращаются в очередь
+	go orchestrator.WatchLeases(expressionDB)
+
 	// Эндпоинты API
 	r.Post("/api/v1/calculate", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         orchestrator.CalculateHandler(w, r, expressionDB)
@@ -33,6 +52,19 @@ func main() {
 	r.Post("/internal/task", func(w http.ResponseWriter, r *http.Request) {
 	orchestrator.PostTaskResultHandler(w, r, expressionDB)
 	})
+	r.Post("/internal/task/{id}/heartbeat", orchestrator.HeartbeatHandler)
+
+	// Агенты регистрируются и сообщают, что они на связи
+	r.Post("/internal/agents/register", orchestrator.RegisterAgentHandler)
+	r.Post("/internal/agents/{id}/heartbeat", orchestrator.AgentHeartbeatHandler)
+
+	// Состояние оркестратора доступно только с токеном администратора
+	r.Group(func(r chi.Router) {
+		r.Use(orchestrator.AdminAuth(os.Getenv("ADMIN_TOKEN")))
+		r.Get("/admin/agents", orchestrator.ListAgentsHandler)
+		r.Get("/admin/queues", orchestrator.ListQueuesHandler)
+		r.Get("/admin/cache", orchestrator.CacheStatsHandler)
+	})
 
 	r.Get("/api/v1/expressions", func(w http.ResponseWriter, r *http.Request) {
     fmt.Println("Получен запрос:", r.Method, r.URL.Path)
@@ -47,6 +79,20 @@ func main() {
 	r.Get("/api/v1/expressions/{id}", func(w http.ResponseWriter, r *http.Request) {
 	orchestrator.GetExpressionByID(w, r, expressionDB)
 	})
+	r.Delete("/api/v1/expressions/{id}", func(w http.ResponseWriter, r *http.Request) {
+	orchestrator.DeleteExpressionHandler(w, r, expressionDB)
+	})
+	r.Get("/api/v1/expressions/{id}/tasks", func(w http.ResponseWriter, r *http.Request) {
+	orchestrator.GetExpressionTasksHandler(w, r, expressionDB)
+	})
+
+	// Потоки событий (Server-Sent Events) о смене статуса и прогрессе выражений
+	r.Get("/api/v1/expressions/events", func(w http.ResponseWriter, r *http.Request) {
+	orchestrator.ExpressionsEventsHandler(w, r, expressionDB)
+	})
+	r.Get("/api/v1/expressions/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+	orchestrator.ExpressionEventsHandler(w, r, expressionDB)
+	})
 
 	r.Post("/api/v1/register", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	orchestrator.RegisterHandler(w, r, userDB)
@@ -55,6 +101,18 @@ func main() {
 	orchestrator.LoginHandler(w, r, userDB)
 	}))
 
+	// gRPC-транспорт для агентов работает параллельно с HTTP
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9090"
+	}
+	go func() {
+		log.Println("gRPC-сервер запущен на", grpcAddr)
+		if err := orchestrator.ServeGRPC(grpcAddr, expressionDB); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
 	// Запуск сервера
 	log.Println("Сервер запущен на :8080")
 	if err := http.ListenAndServe(":8080", r); err != nil {
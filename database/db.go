@@ -2,7 +2,10 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 	"calc/models"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -51,9 +54,67 @@ func InitDB() (*sql.DB, *sql.DB, error) {
 		return nil, nil, err
 	}
 
+	// Колонки, добавленные после создания таблицы
+	for _, column := range []string{"variables", "precision", "result_text", "error", "error_code"} {
+		if err := addColumnIfMissing(expressionDB, "expressions", column, "TEXT"); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Срок хранится в миллисекундах Unix, NULL - без срока
+	if err := addColumnIfMissing(expressionDB, "expressions", "priority", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, nil, err
+	}
+	if err := addColumnIfMissing(expressionDB, "expressions", "deadline", "INTEGER"); err != nil {
+		return nil, nil, err
+	}
+	if err := addColumnIfMissing(expressionDB, "expressions", "min_makespan_ms", "FLOAT NOT NULL DEFAULT 0"); err != nil {
+		return nil, nil, err
+	}
+	if err := addColumnIfMissing(expressionDB, "expressions", "optimize", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return nil, nil, err
+	}
+	if err := addColumnIfMissing(expressionDB, "expressions", "rebalance", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return nil, nil, err
+	}
+	if err := addColumnIfMissing(expressionDB, "expressions", "depth", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, nil, err
+	}
+
 	return userDB, expressionDB, nil
 }
 
+// Добавляем колонку в существующую таблицу, если её ещё нет
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func RegisterUser(db *sql.DB, login, password string) (string, error) {
 	// Проверка существования
 	var existingID string
@@ -84,16 +145,93 @@ func RegisterUser(db *sql.DB, login, password string) (string, error) {
 }
 
 
-func SaveExpressionForUser(dbConn *sql.DB, userID string, id, expression string) error {
+func SaveExpressionForUser(dbConn *sql.DB, userID string, id string, input models.ExpressionInput) error {
+	// Значения переменных и параметры точности храним в JSON рядом с выражением
+	vars, err := toJSON(input.Variables, len(input.Variables) > 0)
+	if err != nil {
+		return err
+	}
+	precision, err := toJSON(input.Precision, input.Precision != nil)
+	if err != nil {
+		return err
+	}
+
+	var deadline sql.NullInt64
+	if input.Deadline != nil {
+		deadline = sql.NullInt64{Int64: input.Deadline.UnixMilli(), Valid: true}
+	}
+
 	// SQL запрос для сохранения
-	insertStmt := `INSERT INTO expressions (user_id, id, expression, status) VALUES (?, ?, ?, ?)`
-	_, err := dbConn.Exec(insertStmt, userID, id, expression, "ожидает выполнения")
+	insertStmt := `INSERT INTO expressions (user_id, id, expression, status, variables, precision, priority, deadline,
+		optimize, rebalance) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = dbConn.Exec(insertStmt, userID, id, input.Expression, models.StatusPending, vars, precision,
+		input.Priority, deadline, input.OptimizeEnabled(), input.RebalanceEnabled())
 	return err
 }
 
+// Сериализуем значение в JSON, если оно задано, иначе пишем NULL
+func toJSON(value interface{}, present bool) (sql.NullString, error) {
+	if !present {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// Колонки выражения в порядке, в котором их читает scanExpression
+const expressionColumns = `id, user_id, expression, status, result, result_text, variables, precision, error, error_code, priority, deadline, min_makespan_ms, optimize, rebalance, depth`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Читаем выражение из строки результата, необязательные колонки могут быть NULL
+func scanExpression(row rowScanner) (*models.Expression, error) {
+	var expr models.Expression
+	var result sql.NullFloat64
+	var resultText, vars, precision, errText, errCode sql.NullString
+
+	var userID sql.NullString
+	var deadline sql.NullInt64
+
+	err := row.Scan(&expr.Id, &userID, &expr.Expression, &expr.Status, &result, &resultText, &vars, &precision, &errText, &errCode,
+		&expr.Priority, &deadline, &expr.MinMakespanMs, &expr.Optimize, &expr.Rebalance, &expr.Depth)
+	if err != nil {
+		return nil, err
+	}
+
+	expr.UserID = userID.String
+	if deadline.Valid {
+		t := time.UnixMilli(deadline.Int64)
+		expr.Deadline = &t
+	}
+
+	// Пока выражение не посчитано, результата в БД нет
+	if result.Valid {
+		expr.Result = result.Float64
+	}
+	expr.ResultText = resultText.String
+	expr.Error = errText.String
+	expr.ErrorCode = errCode.String
+	if vars.Valid {
+		if err := json.Unmarshal([]byte(vars.String), &expr.Variables); err != nil {
+			return nil, err
+		}
+	}
+	if precision.Valid {
+		if err := json.Unmarshal([]byte(precision.String), &expr.Precision); err != nil {
+			return nil, err
+		}
+	}
+	return &expr, nil
+}
+
 
 func GetExpressionsByUser(db *sql.DB, userID string) ([]models.Expression, error) {
-	rows, err := db.Query(`SELECT id, status, result FROM expressions WHERE user_id = ?`, userID)
+	rows, err := db.Query(`SELECT `+expressionColumns+` FROM expressions WHERE user_id = ?`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -101,28 +239,52 @@ func GetExpressionsByUser(db *sql.DB, userID string) ([]models.Expression, error
 
 	var expressions []models.Expression
 	for rows.Next() {
-		var expr models.Expression
-		err := rows.Scan(&expr.Id, &expr.Status, &expr.Result)
+		expr, err := scanExpression(rows)
 		if err != nil {
 			return nil, err
 		}
-		expressions = append(expressions, expr)
+		expressions = append(expressions, *expr)
 	}
-	return expressions, nil
+	return expressions, rows.Err()
 }
 
 func GetExpressionByID(db *sql.DB, id string, userID string) (*models.Expression, error) {
-	query := `SELECT id, status, result FROM expressions WHERE id = ? AND user_id = ?`
-	row := db.QueryRow(query, id, userID)
-
-	var expr models.Expression
-	err := row.Scan(&expr.Id, &expr.Status, &expr.Result)
+	query := `SELECT ` + expressionColumns + ` FROM expressions WHERE id = ? AND user_id = ?`
+	expr, err := scanExpression(db.QueryRow(query, id, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // не найдено
 		}
 		return nil, err
 	}
-	return &expr, nil
+	return expr, nil
 }
 
+// Выражение по ID без проверки владельца, для уведомлений об изменении статуса
+func GetExpression(db *sql.DB, id string) (*models.Expression, error) {
+	expr, err := scanExpression(db.QueryRow(`SELECT `+expressionColumns+` FROM expressions WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return expr, err
+}
+
+// Выражения, вычисление которых не завершено, для восстановления после перезапуска
+func GetUnfinishedExpressions(db *sql.DB) ([]models.Expression, error) {
+	rows, err := db.Query(`SELECT `+expressionColumns+` FROM expressions WHERE status IN (?, ?)`,
+		models.StatusPending, models.StatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expressions []models.Expression
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, *expr)
+	}
+	return expressions, rows.Err()
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"calc/models"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Инициализация хранилища задач
+func InitTaskDB() (*sql.DB, error) {
+	taskDB, err := sql.Open("sqlite3", "./store.db")
+	if err != nil {
+		return nil, err
+	}
+
+	// Операнды, зависимости и параметры точности храним в JSON
+	createTasksTable := `
+		CREATE TABLE IF NOT EXISTS tasks (
+			id TEXT PRIMARY KEY,
+			expression_id TEXT NOT NULL,
+			operation TEXT NOT NULL,
+			operands TEXT NOT NULL,
+			dependencies TEXT,
+			operation_time FLOAT,
+			decimal TEXT,
+			is_final INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			result FLOAT,
+			result_text TEXT,
+			error_code TEXT,
+			error TEXT
+		);
+		CREATE INDEX IF NOT EXISTS tasks_expression_id ON tasks(expression_id);
+	`
+	if _, err := taskDB.Exec(createTasksTable); err != nil {
+		return nil, err
+	}
+
+	// Аренда задачи: число выдач и срок; время создания, выдачи и результата.
+	// Моменты времени храним в миллисекундах Unix, 0 - не задано
+	for _, column := range []string{"attempts", "lease_deadline", "created_at", "started_at", "finished_at"} {
+		if err := addColumnIfMissing(taskDB, "tasks", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return nil, err
+		}
+	}
+	for _, column := range []string{"agent_id", "user_id"} {
+		if err := addColumnIfMissing(taskDB, "tasks", column, "TEXT"); err != nil {
+			return nil, err
+		}
+	}
+
+	return taskDB, nil
+}
+
+// Сохраняем новые задачи одной транзакцией; задачи, посчитанные из кэша,
+// сохраняются сразу с результатом
+func SaveTasks(db *sql.DB, tasks []*models.Task) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO tasks (id, expression_id, user_id, operation, operands, dependencies,
+		operation_time, decimal, is_final, status, result, result_text, created_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, task := range tasks {
+		operands, err := json.Marshal(task.Operands)
+		if err != nil {
+			return err
+		}
+		deps, err := toJSON(task.Dependencies, len(task.Dependencies) > 0)
+		if err != nil {
+			return err
+		}
+		decimal, err := toJSON(task.Decimal, task.Decimal != nil)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.Exec(task.Id, task.ExpressionID, nullString(task.UserID), task.Operation, string(operands), deps,
+			task.Operation_time_ms, decimal, task.IsFinal, task.Status, task.Result, nullString(task.DecimalResult),
+			unixMillis(task.CreatedAt), unixMillis(task.FinishedAt))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Обновляем изменяемые поля задачи: статус, операнды, аренду и результат
+func UpdateTask(db *sql.DB, task *models.Task) error {
+	operands, err := json.Marshal(task.Operands)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`UPDATE tasks SET status = ?, operands = ?, result = ?, result_text = ?, error_code = ?, error = ?,
+		attempts = ?, lease_deadline = ?, agent_id = ?, started_at = ?, finished_at = ? WHERE id = ?`,
+		task.Status, string(operands), task.Result, nullString(task.DecimalResult),
+		nullString(task.ErrorCode), nullString(task.Error), task.Attempts, unixMillis(task.LeaseDeadline),
+		nullString(task.AgentID), unixMillis(task.StartedAt), unixMillis(task.FinishedAt), task.Id)
+	return err
+}
+
+func unixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromUnixMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// Отменяем невыполненные задачи выражения
+func CancelExpressionTasks(db *sql.DB, expressionID string) error {
+	_, err := db.Exec(`UPDATE tasks SET status = ? WHERE expression_id = ? AND status IN (?, ?, ?)`,
+		models.TaskCancelled, expressionID, models.TaskWaiting, models.TaskQueued, models.TaskRunning)
+	return err
+}
+
+// Загружаем все задачи указанных выражений
+func LoadTasks(db *sql.DB, expressionIDs []string) ([]*models.Task, error) {
+	if len(expressionIDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(expressionIDs))
+	for i, id := range expressionIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(expressionIDs)), ", ")
+
+	rows, err := db.Query(`SELECT id, expression_id, user_id, operation, operands, dependencies, operation_time,
+		decimal, is_final, status, result, result_text, error_code, error, attempts, lease_deadline, agent_id,
+		created_at, started_at, finished_at
+		FROM tasks WHERE expression_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*models.Task
+	for rows.Next() {
+		var task models.Task
+		var operands string
+		var userID, deps, decimal, resultText, errCode, errText, agentID sql.NullString
+		var result sql.NullFloat64
+		var lease, created, started, finished int64
+
+		err := rows.Scan(&task.Id, &task.ExpressionID, &userID, &task.Operation, &operands, &deps, &task.Operation_time_ms,
+			&decimal, &task.IsFinal, &task.Status, &result, &resultText, &errCode, &errText, &task.Attempts, &lease, &agentID,
+			&created, &started, &finished)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(operands), &task.Operands); err != nil {
+			return nil, err
+		}
+		if deps.Valid {
+			if err := json.Unmarshal([]byte(deps.String), &task.Dependencies); err != nil {
+				return nil, err
+			}
+		}
+		if decimal.Valid {
+			if err := json.Unmarshal([]byte(decimal.String), &task.Decimal); err != nil {
+				return nil, err
+			}
+		}
+		task.Result = result.Float64
+		task.DecimalResult = resultText.String
+		task.ErrorCode = errCode.String
+		task.Error = errText.String
+		task.AgentID = agentID.String
+		task.UserID = userID.String
+		task.LeaseDeadline = fromUnixMillis(lease)
+		task.CreatedAt = fromUnixMillis(created)
+		task.StartedAt = fromUnixMillis(started)
+		task.FinishedAt = fromUnixMillis(finished)
+
+		tasks = append(tasks, &task)
+	}
+	return tasks, rows.Err()
+}
+
+// Сколько задач выражения выполнено и сколько их всего
+func TaskProgress(db *sql.DB, expressionID string) (done int, total int, err error) {
+	err = db.QueryRow(`SELECT COUNT(CASE WHEN status = ? THEN 1 END), COUNT(*) FROM tasks WHERE expression_id = ?`,
+		models.TaskDone, expressionID).Scan(&done, &total)
+	return done, total, err
+}
+
+// Наибольший числовой ID задачи, чтобы продолжить нумерацию после перезапуска
+func MaxTaskID(db *sql.DB) (int, error) {
+	var maxID sql.NullInt64
+	err := db.QueryRow(`SELECT MAX(CAST(id AS INTEGER)) FROM tasks`).Scan(&maxID)
+	return int(maxID.Int64), err
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -1,6 +1,6 @@
 module calc
 
-go 1.23.2
+go 1.24
 
 require (
 	github.com/go-chi/chi/v5 v5.2.1
@@ -9,4 +9,15 @@ require (
 	golang.org/x/crypto v0.38.0
 )
 
-require github.com/golang-jwt/jwt/v5 v5.2.2
+require (
+	github.com/golang-jwt/jwt/v5 v5.2.2
+	google.golang.org/grpc v1.66.0
+	google.golang.org/protobuf v1.34.1
+)
+
+require (
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.25.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
+)
@@ -2,6 +2,9 @@ package models
 
 import(
 	// "database/sql"
+	"os"
+	"strconv"
+	"time"
 )
 
 var(
@@ -10,18 +13,128 @@ var(
 	Tsub = 5 
 	Tmul = 10
 	Tdiv = 10
+	Tpow = 15
+	Tmod = 10
+	Tidiv = 10
+
+	// Время выполнения встроенных функций
+	FunctionTimes = map[string]int{
+		"sqrt":  20,
+		"abs":   5,
+		"min":   5,
+		"max":   5,
+		"round": 5,
+		"log":   20,
+		"sin":   20,
+		"cos":   20,
+	}
+
+	// Аренда задачи: агент должен прислать результат или продлить аренду
+	// за TLease миллисекунд, иначе задача вернётся в очередь.
+	// После MaxAttempts неудачных выдач выражение завершается ошибкой
+	TLease      = 10000
+	MaxAttempts = 3
+
+	// Сколько задач одного пользователя могут одновременно считать агенты, 0 - без ограничения
+	MaxRunningPerUser = 0
+
+	// Кэш результатов задач и выражений: время жизни записи в миллисекундах
+	// и максимум записей в каждом кэше, 0 - кэш выключен
+	CacheTTL  = 600000
+	CacheSize = 10000
+
+	// Операции с числами, которые выполняются не дольше FoldMaxCost
+	// миллисекунд, оркестратор считает сам, не создавая задач.
+	// 0 - свёртка выключена, все операции считают агенты
+	FoldMaxCost = 0
 )
 
+// Переопределяем время операций и параметры аренды задач
+// из переменных окружения, если они заданы
+func LoadOperationTimes() {
+	envs := map[string]*int{
+		"TIME_ADDITION_MS":        &Tadd,
+		"TIME_SUBTRACTION_MS":     &Tsub,
+		"TIME_MULTIPLICATIONS_MS": &Tmul,
+		"TIME_DIVISIONS_MS":       &Tdiv,
+		"TIME_POWER_MS":           &Tpow,
+		"TIME_MODULO_MS":          &Tmod,
+		"TIME_INT_DIVISION_MS":    &Tidiv,
+		"TASK_LEASE_MS":           &TLease,
+		"TASK_MAX_ATTEMPTS":       &MaxAttempts,
+		"USER_MAX_RUNNING":        &MaxRunningPerUser,
+		"CACHE_TTL_MS":            &CacheTTL,
+		"CACHE_SIZE":              &CacheSize,
+		"OPTIMIZE_FOLD_MAX_MS":    &FoldMaxCost,
+	}
+	for name, target := range envs {
+		if val := os.Getenv(name); val != "" {
+			if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+				*target = n
+			}
+		}
+	}
+}
+
 type ExpressionInput struct {
     Expression string `json:"expression"`
+    Variables map[string]float64 `json:"variables,omitempty"` // значения переменных выражения
+    Precision *Precision `json:"precision,omitempty"` // точный десятичный режим
+    Priority int `json:"priority,omitempty"` // чем больше, тем раньше выдаются задачи выражения
+    Deadline *time.Time `json:"deadline,omitempty"` // после этого момента выражение получает статус timed_out
+    Optimize *bool `json:"optimize,omitempty"` // false - не упрощать дерево перед созданием задач
+    Rebalance *bool `json:"rebalance,omitempty"` // false - считать цепочки + и * в порядке записи
+}
+
+// Оптимизация дерева включена, если её не выключили явно
+func (in ExpressionInput) OptimizeEnabled() bool {
+	return in.Optimize == nil || *in.Optimize
+}
+
+// Перестройка цепочек включена, если её не выключили явно
+func (in ExpressionInput) RebalanceEnabled() bool {
+	return in.Rebalance == nil || *in.Rebalance
+}
+
+// Режимы округления для десятичного режима
+var RoundingModes = []string{"half_even", "half_up", "half_down", "up", "down", "ceiling", "floor"}
+
+// Параметры точного десятичного режима: результаты задач
+// округляются до Scale знаков после запятой
+type Precision struct {
+	Scale    int    `json:"scale"`
+	Rounding string `json:"rounding,omitempty"` // по умолчанию half_even
 }
 
 type Expression struct{
 	Id string		`json:"id"`
+    UserID string `json:"-"`
+    Expression string `json:"expression"`
     Status string	`json:"status"` 
     Result float64	`json:"result"`
+    ResultText string `json:"result_text,omitempty"` // точный результат в десятичном режиме
+    Variables map[string]float64 `json:"variables,omitempty"`
+    Precision *Precision `json:"precision,omitempty"`
+    ErrorCode string `json:"error_code,omitempty"` // причина статуса error
+    Error string `json:"error,omitempty"`
+    Priority int `json:"priority,omitempty"`
+    Deadline *time.Time `json:"deadline,omitempty"`
+    MinMakespanMs float64 `json:"min_makespan_ms"` // время вычисления при неограниченном числе агентов
+    Depth int `json:"depth"` // глубина дерева задач после оптимизации
+    Optimize bool `json:"-"`
+    Rebalance bool `json:"-"`
 }
 
+// Статусы выражений
+const (
+	StatusPending   = "ожидает выполнения"
+	StatusRunning   = "выполняется"
+	StatusDone      = "завершено"
+	StatusError     = "error"
+	StatusTimedOut  = "timed_out" // срок вычисления истёк, оставшиеся задачи отменены
+	StatusCancelled = "cancelled" // отменено пользователем
+)
+
 type Responce1 struct{
 	Id string	`json:"id"`
 }
@@ -33,24 +146,148 @@ type ASTNode struct {
 	Left, Right   *ASTNode
 	TaskID        string
 	TaskScheduled bool
+	Column        int // позиция узла в исходном выражении, считая с 1
+	Args          []*ASTNode // аргументы вызова функции, Operator - имя функции
+	Variable      string     // имя переменной для листа-переменной
+	Literal       string     // исходная запись числа, например 1e-9 или 0xFF
+}
+
+// Операнд задачи: либо число, либо ссылка на результат другой задачи.
+// Оркестратор подставляет результат в Value (и Text) и ставит Resolved
+type Operand struct {
+	Value    float64 `json:"value"`
+	Text     string  `json:"text,omitempty"` // точное значение в десятичном режиме
+	Ref      string  `json:"ref,omitempty"`  // ID задачи, результат которой нужен
+	Resolved bool    `json:"resolved,omitempty"`
+}
+
+// Ready сообщает, известно ли значение операнда
+func (o Operand) Ready() bool {
+	return o.Ref == "" || o.Resolved
 }
 
 type Task struct {
 	Id                string	`json:"id"`
-	Arg1              float64 	`json:"arg1"`
-	Arg2              float64  	`json:"arg2"`
+	Operands          []Operand	`json:"operands"` // по порядку: левый, правый или аргументы функции
 	Operation         string   	`json:"operation"`
 	Result float64				`json:"result"`
 	Operation_time_ms float64	`json:"operation_time"`
 	Dependencies      []string	`json:"dependence"`
-	Status bool					`json:"status"`
+	Status string				`json:"status"`
 	ExpressionID    string   	`json:"expression_id"`
 	IsFinal bool 				`json:"is_final"`
+	// Десятичный режим: операнды и результат в виде строк
+	Decimal *Precision			`json:"decimal,omitempty"`
+	DecimalResult string		`json:"decimal_result,omitempty"`
+	ErrorCode string			`json:"error_code,omitempty"`
+	Error string				`json:"error,omitempty"`
+	// Аренда: сколько раз задачу выдавали агентам и до какого момента
+	// агент должен прислать результат или продлить аренду
+	Attempts int				`json:"attempts"`
+	LeaseDeadline time.Time		`json:"lease_deadline,omitzero"`
+	AgentID string				`json:"agent_id,omitempty"` // агент, которому выдана задача
+	UserID string				`json:"-"` // владелец выражения, для распределения очереди
+	// Приоритет и срок выражения: задачи с большим приоритетом выдаются раньше,
+	// при равном приоритете - с более ранним сроком
+	Priority int				`json:"priority,omitempty"`
+	Deadline time.Time			`json:"deadline,omitzero"`
+	// Длина критического пути: сумма времени операций от этой задачи
+	// до финальной; из готовых задач выражения первой выдаётся самая длинная
+	CriticalPathMs float64		`json:"critical_path_ms"`
+	// Время создания задачи, последней выдачи агенту и получения результата
+	CreatedAt time.Time			`json:"created_at,omitzero"`
+	StartedAt time.Time			`json:"started_at,omitzero"`
+	FinishedAt time.Time		`json:"finished_at,omitzero"`
+}
+
+// Статусы задач
+const (
+	TaskWaiting   = "ожидает"     // ждёт результаты зависимостей
+	TaskQueued    = "в очереди"   // готова к выдаче агенту
+	TaskRunning   = "выполняется" // выдана агенту
+	TaskDone      = "завершена"
+	TaskFailed    = "ошибка"
+	TaskCancelled = "отменена"
+)
+
+// Коды ошибок вычисления задач
+const (
+	ErrDivisionByZero   = "division_by_zero"
+	ErrDomain           = "domain_error" // аргумент вне области определения
+	ErrOverflow         = "overflow"
+	ErrUnknownOperation = "unknown_operation"
+	ErrInvalidArgument  = "invalid_argument"
+	ErrAttemptsExceeded = "attempts_exceeded" // аренда задачи истекла MaxAttempts раз
+)
+
+// Ошибка вычисления задачи с машинно-читаемым кодом
+type TaskError struct {
+	Code    string
+	Message string
+}
+
+func (e *TaskError) Error() string {
+	return e.Message
 }
 
+// Тело ответа с описанием ошибки разбора выражения
+type ErrorResponse struct {
+	Error    string   `json:"error"`
+	Message  string   `json:"message,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Token    string   `json:"token,omitempty"`
+	Expected []string `json:"expected,omitempty"`
+}
+
+// Результат задачи, который агент отправляет оркестратору
 type Responce2 struct{
-	Id string		`json:"id"`
+	Id string		`json:"id"` // ID задачи
   	Result float64	`json:"result"`
+  	ResultText string `json:"result_text,omitempty"`
+  	ErrorCode string `json:"error_code,omitempty"` // задача завершилась ошибкой
+  	Error string `json:"error,omitempty"`
+}
+
+// Несколько задач, выданных агенту одним запросом
+type TaskBatch struct {
+	Tasks []*Task `json:"tasks"`
+}
+
+// Результаты нескольких задач, отправленные одним запросом
+type ResultBatch struct {
+	Results []Responce2 `json:"results"`
+}
+
+// Итог приёма одного результата из пакета
+type ResultStatus struct {
+	Id     string `json:"id"`
+	Status string `json:"status"` // accepted, not_found или error
+	Error  string `json:"error,omitempty"`
+}
+
+// Ответ на продление аренды задачи
+type LeaseResponse struct {
+	Id            string    `json:"id"`
+	LeaseDeadline time.Time `json:"lease_deadline"`
+}
+
+// Данные, с которыми агент регистрируется у оркестратора
+type AgentRegistration struct {
+	Id             string   `json:"id,omitempty"` // если не задан, ID выдаёт оркестратор
+	ComputingPower int      `json:"computing_power"`
+	Version        string   `json:"version"`
+	Operations     []string `json:"operations"` // пустой список - агент умеет всё
+}
+
+// Состояние агента для эндпоинта /admin/agents
+type Agent struct {
+	AgentRegistration
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+	Online       bool      `json:"online"`
+	InFlight     []string  `json:"in_flight"` // ID задач, которые агент сейчас считает
+	Completed    int       `json:"completed"`
+	Failed       int       `json:"failed"`
 }
 
 type User struct {
@@ -58,3 +295,17 @@ type User struct {
 	Login    string `json:"login"`    
 	Password string `json:"password"` 
 }
+
+// Событие потока /api/v1/expressions/events: смена статуса выражения
+// или выполнение очередной задачи
+type ExpressionEvent struct {
+	Id         string  `json:"id"`
+	Status     string  `json:"status"`
+	Progress   float64 `json:"progress"` // доля выполненных задач в процентах
+	TasksDone  int     `json:"tasks_done"`
+	TasksTotal int     `json:"tasks_total"`
+	Result     float64 `json:"result"`
+	ResultText string  `json:"result_text,omitempty"`
+	ErrorCode  string  `json:"error_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
@@ -0,0 +1,250 @@
+package operations
+
+import (
+	"fmt"
+	"math/big"
+
+	"calc/models"
+)
+
+// Предел показателя степени для точного возведения в целую степень
+const maxExactExponent = 10000
+
+// Предел размера значения: числитель и знаменатель результата не длиннее
+// maxDecimalDigits десятичных знаков (в битах - maxDecimalBits). Без него
+// цепочка степеней вроде (10^9999)^500 даёт операнды в миллионы знаков
+const (
+	maxDecimalDigits = 10000
+	maxDecimalBits   = maxDecimalDigits * 3322 / 1000 // log2(10) ≈ 3.322
+)
+
+// Предел числа знаков в round(x, digits), как у точности выражения
+const maxRoundDigits = 1000
+
+var bigTen = big.NewInt(10)
+
+// Вычисление задачи в десятичном режиме: операнды и результат - строки,
+// промежуточные значения - big.Rat, результат округляется до task.Decimal.Scale
+func PerformDecimalOperation(task *models.Task) (string, error) {
+	args := make([]*big.Rat, len(task.Operands))
+	for i, operand := range task.Operands {
+		r, ok := new(big.Rat).SetString(operand.Text)
+		if !ok {
+			return "", &models.TaskError{
+				Code:    models.ErrInvalidArgument,
+				Message: fmt.Sprintf("операнд %d: некорректное десятичное значение %q", i+1, operand.Text),
+			}
+		}
+		args[i] = r
+	}
+
+	result, err := decimalOperation(task.Operation, args, task.Decimal)
+	if err != nil {
+		return "", err
+	}
+	if tooLarge(result) {
+		return "", errOverflow
+	}
+
+	rounded := roundRat(result, task.Decimal.Scale, task.Decimal.Rounding)
+	return rounded.FloatString(task.Decimal.Scale), nil
+}
+
+// RoundDecimal округляет число до точности выражения так же, как результат
+// задачи: выражение из одного числа оркестратор считает без агента
+func RoundDecimal(text string, precision *models.Precision) (string, error) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return "", &models.TaskError{
+			Code:    models.ErrInvalidArgument,
+			Message: fmt.Sprintf("некорректное десятичное значение %q", text),
+		}
+	}
+	if tooLarge(r) {
+		return "", errOverflow
+	}
+	return roundRat(r, precision.Scale, precision.Rounding).FloatString(precision.Scale), nil
+}
+
+func decimalOperation(op string, args []*big.Rat, precision *models.Precision) (*big.Rat, error) {
+	r := new(big.Rat)
+
+	switch op {
+	case "+":
+		return r.Add(args[0], args[1]), nil
+	case "-":
+		return r.Sub(args[0], args[1]), nil
+	case "*":
+		return r.Mul(args[0], args[1]), nil
+	case "u-":
+		return r.Neg(args[0]), nil
+	case "/":
+		if args[1].Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		return r.Quo(args[0], args[1]), nil
+	case "//":
+		if args[1].Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		return roundRat(r.Quo(args[0], args[1]), 0, "floor"), nil
+	case "%":
+		// Остаток согласован с //: a == b*(a//b) + a%b, знак как у делителя
+		if args[1].Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		quotient := roundRat(new(big.Rat).Quo(args[0], args[1]), 0, "floor")
+		return r.Sub(args[0], quotient.Mul(quotient, args[1])), nil
+	case "^":
+		return ratPow(args[0], args[1])
+	case "abs":
+		return r.Abs(args[0]), nil
+	case "min", "max":
+		r.Set(args[0])
+		for _, arg := range args[1:] {
+			if (op == "min" && arg.Cmp(r) < 0) || (op == "max" && arg.Cmp(r) > 0) {
+				r.Set(arg)
+			}
+		}
+		return r, nil
+	case "round":
+		digits := 0
+		if len(args) > 1 {
+			d, _ := args[1].Float64()
+			var err error
+			if digits, err = roundDigits(d); err != nil {
+				return nil, err
+			}
+		}
+		return roundRat(args[0], digits, precision.Rounding), nil
+	case "sqrt":
+		if args[0].Sign() < 0 {
+			return nil, domainError("корень из отрицательного числа")
+		}
+		return ratSqrt(args[0], precision.Scale), nil
+	case "log", "sin", "cos":
+		// Для трансцендентных функций точного представления нет, считаем во float64
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			values[i], _ = arg.Float64()
+		}
+		value, err := performFloatOperation(op, values)
+		if err != nil {
+			return nil, err
+		}
+		return r.SetFloat64(value), nil
+	default:
+		return nil, &models.TaskError{
+			Code:    models.ErrUnknownOperation,
+			Message: fmt.Sprintf("неизвестная операция %q", op),
+		}
+	}
+}
+
+// Возведение в степень: целые показатели считаются точно,
+// дробные - через float64
+func ratPow(base, exponent *big.Rat) (*big.Rat, error) {
+	if exponent.IsInt() && exponent.Num().IsInt64() {
+		n := exponent.Num().Int64()
+		if n > -maxExactExponent && n < maxExactExponent {
+			if n < 0 && base.Sign() == 0 {
+				return nil, errDivisionByZero
+			}
+			// Размер результата оцениваем до вычисления
+			bits := max(base.Num().BitLen(), base.Denom().BitLen())
+			if bits > 1 && int64(bits-1)*int64(abs(int(n))) > maxDecimalBits {
+				return nil, errOverflow
+			}
+			k := big.NewInt(int64(abs(int(n))))
+			num := new(big.Int).Exp(base.Num(), k, nil)
+			den := new(big.Int).Exp(base.Denom(), k, nil)
+			if n < 0 {
+				num, den = den, num
+			}
+			return new(big.Rat).SetFrac(num, den), nil
+		}
+	}
+
+	b, _ := base.Float64()
+	e, _ := exponent.Float64()
+	result, err := performFloatOperation("^", []float64{b, e})
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).SetFloat64(result), nil
+}
+
+// Квадратный корень до scale знаков после запятой: целый корень из x·10^(2k),
+// k = scale+1. Лишний знак и единица после него, если корень не извлёкся
+// точно, нужны, чтобы roundRat округлил результат как точное значение
+func ratSqrt(x *big.Rat, scale int) *big.Rat {
+	factor := new(big.Int).Exp(bigTen, big.NewInt(int64(scale+1)), nil)
+	num := new(big.Int).Mul(x.Num(), new(big.Int).Mul(factor, factor))
+	root := new(big.Int).Sqrt(new(big.Int).Quo(num, x.Denom()))
+
+	square := new(big.Int).Mul(root, root)
+	if square.Mul(square, x.Denom()).Cmp(num) == 0 {
+		return new(big.Rat).SetFrac(root, factor)
+	}
+	root.Mul(root, bigTen).Add(root, big.NewInt(1))
+	return new(big.Rat).SetFrac(root, factor.Mul(factor, bigTen))
+}
+
+// Значение не помещается в предел maxDecimalDigits
+func tooLarge(x *big.Rat) bool {
+	return x.Num().BitLen() > maxDecimalBits || x.Denom().BitLen() > maxDecimalBits
+}
+
+// Округление до scale знаков после запятой в заданном режиме
+func roundRat(x *big.Rat, scale int, mode string) *big.Rat {
+	factor := new(big.Int).Exp(bigTen, big.NewInt(int64(abs(scale))), nil)
+
+	num := new(big.Int).Set(x.Num())
+	den := new(big.Int).Set(x.Denom())
+	if scale >= 0 {
+		num.Mul(num, factor)
+	} else {
+		den.Mul(den, factor)
+	}
+
+	// QuoRem отбрасывает дробную часть в сторону нуля
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() != 0 {
+		sign := int64(x.Sign())
+		twice := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2))
+		half := twice.Cmp(den)
+
+		var away bool
+		switch mode {
+		case "up":
+			away = true
+		case "down":
+			away = false
+		case "ceiling":
+			away = sign > 0
+		case "floor":
+			away = sign < 0
+		case "half_up":
+			away = half >= 0
+		case "half_down":
+			away = half > 0
+		default: // half_even
+			away = half > 0 || (half == 0 && q.Bit(0) == 1)
+		}
+		if away {
+			q.Add(q, big.NewInt(sign))
+		}
+	}
+
+	if scale >= 0 {
+		return new(big.Rat).SetFrac(q, factor)
+	}
+	return new(big.Rat).SetInt(q.Mul(q, factor))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
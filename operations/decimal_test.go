@@ -0,0 +1,79 @@
+package operations
+
+import (
+	"calc/models"
+	"testing"
+)
+
+func TestDecimalSqrt(t *testing.T) {
+	tests := []struct {
+		operand  string
+		scale    int
+		rounding string
+		want     string
+	}{
+		// Целая часть не отнимает знаки у дробной
+		{"2e41", 10, "", "447213595499957939281.8347337463"},
+		{"12345678901234567890123456789", 5, "", "111111110611111.10994"},
+		{"2", 20, "", "1.41421356237309504880"},
+		{"2", 0, "", "1"},
+		{"1e-30", 16, "", "0.0000000000000010"},
+		// Корень извлекается точно: 0.25 - ровно середина между 0.2 и 0.3
+		{"0.0625", 2, "", "0.25"},
+		{"0.0625", 1, "", "0.2"},
+		{"0.0625", 1, "half_down", "0.2"},
+		{"0.0625", 1, "half_up", "0.3"},
+		{"0.0625", 1, "floor", "0.2"},
+		{"0.0625", 1, "up", "0.3"},
+		// Корень чуть больше середины
+		{"0.06250001", 1, "", "0.3"},
+		{"0.06250001", 1, "half_down", "0.3"},
+		{"0.06250001", 1, "down", "0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operand, func(t *testing.T) {
+			task := &models.Task{
+				Operation: "sqrt",
+				Operands:  []models.Operand{{Text: tt.operand}},
+				Decimal:   &models.Precision{Scale: tt.scale, Rounding: tt.rounding},
+			}
+			got, err := PerformDecimalOperation(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sqrt(%s) = %s, ожидалось %s", tt.operand, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundDecimal(t *testing.T) {
+	tests := []struct {
+		text     string
+		scale    int
+		rounding string
+		want     string
+	}{
+		{"0.123456", 2, "half_even", "0.12"},
+		{"0.125", 2, "half_even", "0.12"},
+		{"0.125", 2, "half_up", "0.13"},
+		{"-0.125", 2, "floor", "-0.13"},
+		{"5", 3, "half_even", "5.000"},
+		{"1e3", 0, "half_even", "1000"},
+		{"0.0000000001", 5, "up", "0.00001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := RoundDecimal(tt.text, &models.Precision{Scale: tt.scale, Rounding: tt.rounding})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s до %d знаков = %s, ожидалось %s", tt.text, tt.scale, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package operations
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"calc/models"
+)
+
+// Операции, которые умеет выполнять агент
+var Supported = []string{
+	"+", "-", "*", "/", "u-", "^", "%", "//",
+	"sqrt", "abs", "min", "max", "round", "log", "sin", "cos",
+}
+
+// Выполняем задачу и готовим результат для оркестратора
+func Perform(task *models.Task) models.Responce2 {
+	result, _ := PerformOperation(task)
+	return models.Responce2{
+		Id:         task.Id,
+		Result:     result,
+		ResultText: task.DecimalResult,
+		ErrorCode:  task.ErrorCode,
+		Error:      task.Error,
+	}
+}
+
+// Функция для выполнения операции (например, сложение, вычитание).
+// При арифметической ошибке задача получает код и текст ошибки
+func PerformOperation(task *models.Task) (float64, error) {
+	err := checkOperands(task)
+	switch {
+	case err != nil:
+	case task.Decimal != nil:
+		// В десятичном режиме считаем точно, float64 заполняем для совместимости.
+		// Результат вне диапазона float64 остаётся только в DecimalResult,
+		// бесконечность нельзя передать в JSON
+		task.DecimalResult, err = PerformDecimalOperation(task)
+		if err == nil {
+			if value, parseErr := strconv.ParseFloat(task.DecimalResult, 64); parseErr == nil {
+				task.Result = value
+			}
+		}
+	default:
+		args := make([]float64, len(task.Operands))
+		for i, operand := range task.Operands {
+			args[i] = operand.Value
+		}
+		task.Result, err = performFloatOperation(task.Operation, args)
+	}
+
+	if err != nil {
+		task.Result = 0
+		task.DecimalResult = ""
+		task.ErrorCode, task.Error = taskErrorCode(err), err.Error()
+	}
+
+	// log.Printf("Задача %s выполнена. Результат: %f", task.Id, task.Result)
+
+	return task.Result, err
+}
+
+// Все операнды должны быть известны, а их число - соответствовать операции
+func checkOperands(task *models.Task) error {
+	for i, operand := range task.Operands {
+		if !operand.Ready() {
+			return &models.TaskError{
+				Code:    models.ErrInvalidArgument,
+				Message: fmt.Sprintf("операнд %d: не подставлен результат задачи %s", i+1, operand.Ref),
+			}
+		}
+	}
+
+	// Бинарным операциям нужно ровно два операнда, остальным - хотя бы один
+	count := len(task.Operands)
+	switch task.Operation {
+	case "+", "-", "*", "/", "^", "%", "//":
+		if count == 2 {
+			return nil
+		}
+	default:
+		if count > 0 {
+			return nil
+		}
+	}
+	return &models.TaskError{
+		Code:    models.ErrInvalidArgument,
+		Message: fmt.Sprintf("операция %q: неверное число операндов (%d)", task.Operation, count),
+	}
+}
+
+// Вычисление во float64, args - значения операндов по порядку
+func performFloatOperation(op string, args []float64) (float64, error) {
+	var result float64
+
+	switch op {
+	case "+":
+		result = args[0] + args[1]
+	case "-":
+		result = args[0] - args[1]
+	case "*":
+		result = args[0] * args[1]
+	case "u-":
+		result = -args[0]
+	case "/":
+		if args[1] == 0 {
+			return 0, errDivisionByZero
+		}
+		result = args[0] / args[1]
+	case "^":
+		if args[0] == 0 && args[1] < 0 {
+			return 0, errDivisionByZero
+		}
+		result = math.Pow(args[0], args[1])
+	case "%":
+		// Остаток согласован с //: a == b*(a//b) + a%b, знак как у делителя
+		if args[1] == 0 {
+			return 0, errDivisionByZero
+		}
+		result = math.Mod(args[0], args[1])
+		if result != 0 && (result < 0) != (args[1] < 0) {
+			result += args[1]
+		}
+	case "//":
+		if args[1] == 0 {
+			return 0, errDivisionByZero
+		}
+		result = math.Floor(args[0] / args[1])
+	case "sqrt":
+		if args[0] < 0 {
+			return 0, domainError("корень из отрицательного числа")
+		}
+		result = math.Sqrt(args[0])
+	case "abs":
+		result = math.Abs(args[0])
+	case "min":
+		result = args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+	case "max":
+		result = args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+	case "round":
+		// round(x) или round(x, digits)
+		digits := 0
+		if len(args) > 1 {
+			var err error
+			if digits, err = roundDigits(args[1]); err != nil {
+				return 0, err
+			}
+		}
+		result = roundFloat(args[0], digits)
+	case "log":
+		// log(x) - натуральный, log(x, base) - по основанию
+		if err := checkLogArgs(args); err != nil {
+			return 0, err
+		}
+		result = math.Log(args[0])
+		if len(args) > 1 {
+			result /= math.Log(args[1])
+		}
+	case "sin":
+		result = math.Sin(args[0])
+	case "cos":
+		result = math.Cos(args[0])
+	default:
+		return 0, &models.TaskError{
+			Code:    models.ErrUnknownOperation,
+			Message: fmt.Sprintf("неизвестная операция %q", op),
+		}
+	}
+
+	// NaN и бесконечность нельзя передать в JSON
+	if math.IsNaN(result) {
+		return 0, domainError("результат не определён")
+	}
+	if math.IsInf(result, 0) {
+		return 0, errOverflow
+	}
+	return result, nil
+}
+
+// Число знаков round: целая часть аргумента, не больше maxRoundDigits по модулю
+func roundDigits(d float64) (int, error) {
+	if math.Abs(d) > maxRoundDigits {
+		return 0, &models.TaskError{
+			Code:    models.ErrInvalidArgument,
+			Message: fmt.Sprintf("число знаков округления должно быть от %d до %d", -maxRoundDigits, maxRoundDigits),
+		}
+	}
+	return int(math.Trunc(d)), nil
+}
+
+// Округление до digits знаков после запятой, при digits < 0 - до десятков,
+// сотен и т.д. 10^digits вычисляется только в пределах диапазона float64
+func roundFloat(x float64, digits int) float64 {
+	if digits < 0 {
+		// Любое float64 меньше половины 10^309
+		if digits < -308 {
+			return 0
+		}
+		scale := math.Pow10(-digits)
+		return math.Round(x/scale) * scale
+	}
+
+	// Знаков больше, чем хранит float64: x уже округлено
+	if digits > 308 {
+		return x
+	}
+	scale := math.Pow10(digits)
+	scaled := x * scale
+	if math.IsInf(scaled, 0) || math.Abs(scaled) >= 1<<53 {
+		return x
+	}
+	return math.Round(scaled) / scale
+}
+
+var (
+	errDivisionByZero = &models.TaskError{Code: models.ErrDivisionByZero, Message: "деление на ноль"}
+	errOverflow       = &models.TaskError{Code: models.ErrOverflow, Message: "переполнение"}
+)
+
+func domainError(msg string) *models.TaskError {
+	return &models.TaskError{Code: models.ErrDomain, Message: msg}
+}
+
+// Логарифм определён для x > 0 и основания > 0, не равного 1
+func checkLogArgs(args []float64) error {
+	if args[0] <= 0 {
+		return domainError("логарифм неположительного числа")
+	}
+	if len(args) > 1 && (args[1] <= 0 || args[1] == 1) {
+		return domainError("недопустимое основание логарифма")
+	}
+	return nil
+}
+
+func taskErrorCode(err error) string {
+	var taskErr *models.TaskError
+	if errors.As(err, &taskErr) {
+		return taskErr.Code
+	}
+	return models.ErrInvalidArgument
+}
@@ -0,0 +1,97 @@
+package operations
+
+import (
+	"calc/models"
+	"strconv"
+	"testing"
+)
+
+// // и % согласованы: a == b*(a//b) + a%b при любых знаках
+func TestFloorDivMod(t *testing.T) {
+	tests := []struct {
+		a, b        float64
+		quot, rem   float64
+		decimalQuot string
+		decimalRem  string
+	}{
+		{7, 2, 3, 1, "3", "1"},
+		{-7, 2, -4, 1, "-4", "1"},
+		{7, -2, -4, -1, "-4", "-1"},
+		{-7, -2, 3, -1, "3", "-1"},
+		{6, -3, -2, 0, "-2", "0"},
+		{-7.5, 2, -4, 0.5, "-4", "0.5"},
+	}
+
+	perform := func(op string, a, b float64, decimal bool) (float64, string) {
+		task := &models.Task{Operation: op, Operands: []models.Operand{{Value: a}, {Value: b}}}
+		if decimal {
+			task.Decimal = &models.Precision{Scale: 1, Rounding: "half_even"}
+			for i := range task.Operands {
+				task.Operands[i].Text = strconv.FormatFloat(task.Operands[i].Value, 'f', -1, 64)
+			}
+		}
+		result, err := PerformOperation(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result, task.DecimalResult
+	}
+
+	for _, tt := range tests {
+		name := strconv.FormatFloat(tt.a, 'g', -1, 64) + "," + strconv.FormatFloat(tt.b, 'g', -1, 64)
+		t.Run(name, func(t *testing.T) {
+			quot, _ := perform("//", tt.a, tt.b, false)
+			rem, _ := perform("%", tt.a, tt.b, false)
+			if quot != tt.quot || rem != tt.rem {
+				t.Errorf("a//b = %v, a%%b = %v, ожидалось %v и %v", quot, rem, tt.quot, tt.rem)
+			}
+			if got := tt.b*quot + rem; got != tt.a {
+				t.Errorf("b*(a//b) + a%%b = %v, ожидалось %v", got, tt.a)
+			}
+
+			_, decimalQuot := perform("//", tt.a, tt.b, true)
+			_, decimalRem := perform("%", tt.a, tt.b, true)
+			wantQuot, _ := RoundDecimal(tt.decimalQuot, &models.Precision{Scale: 1})
+			wantRem, _ := RoundDecimal(tt.decimalRem, &models.Precision{Scale: 1})
+			if decimalQuot != wantQuot || decimalRem != wantRem {
+				t.Errorf("в десятичном режиме a//b = %s, a%%b = %s, ожидалось %s и %s", decimalQuot, decimalRem, wantQuot, wantRem)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		x, digits float64
+		want      float64
+	}{
+		{1.5, 0, 2},
+		{1.25, 1, 1.3},
+		{1234.5, -2, 1200},
+		{1.5, 400, 1.5},
+		{1.5, 308, 1.5},
+		{0.1, 20, 0.1},
+		{1e300, 10, 1e300},
+		{123, -400, 0},
+		{-123, -400, 0},
+		{6e307, -308, 1e308},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatFloat(tt.digits, 'g', -1, 64), func(t *testing.T) {
+			task := &models.Task{Operation: "round", Operands: []models.Operand{{Value: tt.x}, {Value: tt.digits}}}
+			got, err := PerformOperation(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("round(%v, %v) = %v, ожидалось %v", tt.x, tt.digits, got, tt.want)
+			}
+		})
+	}
+
+	task := &models.Task{Operation: "round", Operands: []models.Operand{{Value: 1.5}, {Value: 1001}}}
+	if _, err := PerformOperation(task); task.ErrorCode != models.ErrInvalidArgument {
+		t.Errorf("round(1.5, 1001): ошибка %v, код %q", err, task.ErrorCode)
+	}
+}
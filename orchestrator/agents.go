@@ -0,0 +1,147 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Агент считается отключённым, если от него не было запросов дольше этого срока
+const agentOfflineAfter = 30 * time.Second
+
+var (
+	agents      = make(map[string]*models.Agent)
+	agentsMutex sync.Mutex
+)
+
+// Регистрация агента: агент сообщает число воркеров, версию и операции,
+// которые умеет выполнять, и получает ID для запросов задач
+func RegisterAgentHandler(w http.ResponseWriter, r *http.Request) {
+	var reg models.AgentRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, "невалидные данные", http.StatusUnprocessableEntity)
+		return
+	}
+	id := registerAgent(reg)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.Responce1{Id: id})
+}
+
+// Добавляем агента в список и возвращаем его ID
+func registerAgent(reg models.AgentRegistration) string {
+	if reg.Id == "" {
+		reg.Id = uuid.New().String()
+	}
+
+	now := time.Now()
+	agentsMutex.Lock()
+	defer agentsMutex.Unlock()
+
+	agent, ok := agents[reg.Id]
+	if !ok {
+		agent = &models.Agent{RegisteredAt: now}
+		agents[reg.Id] = agent
+	}
+	// Повторная регистрация обновляет параметры, счётчики сохраняются
+	agent.AgentRegistration = reg
+	agent.LastSeen = now
+	return reg.Id
+}
+
+// Heartbeat агента. 404 означает, что агент неизвестен (например, оркестратор
+// перезапускался) и ему нужно зарегистрироваться заново
+func AgentHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if !touchAgent(chi.URLParam(r, "id")) {
+		http.Error(w, "агент не зарегистрирован", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Отмечаем, что агент на связи
+func touchAgent(id string) bool {
+	agentsMutex.Lock()
+	defer agentsMutex.Unlock()
+
+	agent, ok := agents[id]
+	if ok {
+		agent.LastSeen = time.Now()
+	}
+	return ok
+}
+
+// Операции, которые умеет выполнять агент; nil - любые
+func agentOperations(id string) []string {
+	agentsMutex.Lock()
+	defer agentsMutex.Unlock()
+
+	if agent, ok := agents[id]; ok {
+		return agent.Operations
+	}
+	return nil
+}
+
+// Учитываем результат задачи в счётчиках агента, который её считал
+func countAgentResult(id string, failed bool) {
+	agentsMutex.Lock()
+	defer agentsMutex.Unlock()
+
+	agent, ok := agents[id]
+	if !ok {
+		return
+	}
+	if failed {
+		agent.Failed++
+	} else {
+		agent.Completed++
+	}
+}
+
+// Список агентов с задачами, которые они сейчас считают
+func ListAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	agentsMutex.Lock()
+	list := make([]models.Agent, 0, len(agents))
+	for _, agent := range agents {
+		list = append(list, *agent)
+	}
+	agentsMutex.Unlock()
+
+	inFlight := make(map[string][]string)
+	TaskMutex.Lock()
+	for _, task := range Tasks {
+		if task.Status == models.TaskRunning && task.AgentID != "" {
+			inFlight[task.AgentID] = append(inFlight[task.AgentID], task.Id)
+		}
+	}
+	TaskMutex.Unlock()
+
+	now := time.Now()
+	for i := range list {
+		list[i].Online = now.Sub(list[i].LastSeen) < agentOfflineAfter
+		list[i].InFlight = inFlight[list[i].Id]
+		if list[i].InFlight == nil {
+			list[i].InFlight = []string{}
+		}
+		slices.Sort(list[i].InFlight)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RegisteredAt.Before(list[j].RegisteredAt)
+	})
+
+	var response struct {
+		Agents []models.Agent `json:"agents"`
+	}
+	response.Agents = list
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
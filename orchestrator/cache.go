@@ -0,0 +1,193 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Закэшированный результат задачи или выражения
+type cachedResult struct {
+	Result     float64
+	ResultText string
+}
+
+// LRU-кэш результатов с ограничением по числу записей и времени жизни.
+// Ключ - хэш канонической записи задачи или выражения
+type resultCache struct {
+	mu      sync.Mutex
+	items   map[string]*list.Element
+	order   *list.List // в начале - недавно использованные
+	hits    uint64
+	misses  uint64
+	evicted uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   cachedResult
+	expires time.Time
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *resultCache) get(key string) (cachedResult, bool) {
+	if models.CacheSize <= 0 {
+		return cachedResult{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if ok && time.Now().After(elem.Value.(*cacheEntry).expires) {
+		c.removeElement(elem)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return cachedResult{}, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+func (c *resultCache) put(key string, value cachedResult) {
+	if models.CacheSize <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(time.Duration(models.CacheTTL) * time.Millisecond)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > models.CacheSize {
+		c.removeElement(c.order.Back())
+		c.evicted++
+	}
+}
+
+func (c *resultCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*cacheEntry).key)
+}
+
+// Счётчики кэша для /admin/cache
+type cacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Evicted uint64 `json:"evicted"`
+}
+
+func (c *resultCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{Entries: c.order.Len(), Hits: c.hits, Misses: c.misses, Evicted: c.evicted}
+}
+
+var (
+	// Результаты задач по операции и значениям операндов
+	taskCache = newResultCache()
+	// Результаты выражений, отправленных на /api/v1/calculate
+	expressionCache = newResultCache()
+	// Сколько одинаковых поддеревьев внутри выражений посчитано одной задачей
+	memoizedSubtrees atomic.Uint64
+
+	// Ключи выражений, которые сейчас считаются: результат попадёт в кэш
+	// после завершения выражения
+	pendingKeys   = make(map[string]string)
+	pendingKeysMu sync.Mutex
+)
+
+func cacheKey(canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:])
+}
+
+// Ключ задачи: операция, точность и значения операндов.
+// Вызывается для задачи, у которой известны все операнды
+func taskKey(task *models.Task) string {
+	var b strings.Builder
+	b.WriteString(task.Operation)
+	if task.Decimal != nil {
+		b.WriteString("|" + strconv.Itoa(task.Decimal.Scale) + "|" + task.Decimal.Rounding)
+	}
+	for _, operand := range task.Operands {
+		b.WriteString("|")
+		if task.Decimal != nil {
+			b.WriteString(operand.Text)
+		} else {
+			b.WriteString(strconv.FormatFloat(operand.Value, 'g', -1, 64))
+		}
+	}
+	return cacheKey(b.String())
+}
+
+// Ключ выражения: запись без пробелов, значения переменных, точность
+// и флаги оптимизации и перестройки, от которых зависит порядок операций
+func expressionKey(input models.ExpressionInput) string {
+	// json.Marshal сортирует ключи map, поэтому запись переменных однозначна
+	vars, _ := json.Marshal(input.Variables)
+	precision, _ := json.Marshal(input.Precision)
+	flags := strconv.FormatBool(input.OptimizeEnabled()) + "|" + strconv.FormatBool(input.RebalanceEnabled())
+	return cacheKey(input.Expression + "|" + string(vars) + "|" + string(precision) + "|" + flags)
+}
+
+// Запоминаем ключ выражения, чтобы закэшировать его результат
+func rememberExpressionKey(id string, key string) {
+	pendingKeysMu.Lock()
+	defer pendingKeysMu.Unlock()
+	pendingKeys[id] = key
+}
+
+// Кэшируем результат выражения, если оно посчитано успешно; в любом
+// случае забываем его ключ
+func storeExpressionResult(id string, result *cachedResult) {
+	pendingKeysMu.Lock()
+	key, ok := pendingKeys[id]
+	delete(pendingKeys, id)
+	pendingKeysMu.Unlock()
+
+	if ok && result != nil {
+		expressionCache.put(key, *result)
+	}
+}
+
+// Состояние кэшей: число записей, попадания, промахи и вытеснения
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var response struct {
+		TTLMs            int        `json:"ttl_ms"`
+		Size             int        `json:"size"` // максимум записей в каждом кэше
+		Tasks            cacheStats `json:"tasks"`
+		Expressions      cacheStats `json:"expressions"`
+		MemoizedSubtrees uint64     `json:"memoized_subtrees"`
+	}
+	response.TTLMs = models.CacheTTL
+	response.Size = models.CacheSize
+	response.Tasks = taskCache.stats()
+	response.Expressions = expressionCache.stats()
+	response.MemoizedSubtrees = memoizedSubtrees.Load()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
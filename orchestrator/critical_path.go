@@ -0,0 +1,37 @@
+package orchestrator
+
+import "calc/models"
+
+// Считаем для каждой задачи выражения длину критического пути - сумму
+// времени операций по самой длинной цепочке от задачи до финальной.
+// Возвращаем минимальное время вычисления выражения: длину самого
+// длинного пути, если свободных агентов всегда достаточно
+func assignCriticalPaths(tasks []*models.Task) float64 {
+	dependentsOf := make(map[string][]*models.Task)
+	for _, task := range tasks {
+		for _, depID := range task.Dependencies {
+			dependentsOf[depID] = append(dependentsOf[depID], task)
+		}
+	}
+
+	done := make(map[string]bool)
+	var path func(task *models.Task) float64
+	path = func(task *models.Task) float64 {
+		if done[task.Id] {
+			return task.CriticalPathMs
+		}
+		longest := 0.0
+		for _, dependent := range dependentsOf[task.Id] {
+			longest = max(longest, path(dependent))
+		}
+		task.CriticalPathMs = task.Operation_time_ms + longest
+		done[task.Id] = true
+		return task.CriticalPathMs
+	}
+
+	makespan := 0.0
+	for _, task := range tasks {
+		makespan = max(makespan, path(task))
+	}
+	return makespan
+}
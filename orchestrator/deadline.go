@@ -0,0 +1,40 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Ставим таймер на срок выражения: если к этому моменту выражение
+// не посчитано, оно получает статус timed_out, а его задачи отменяются
+func scheduleDeadline(db *sql.DB, expr *models.Expression) {
+	if expr.Deadline == nil {
+		return
+	}
+
+	id := expr.Id
+	time.AfterFunc(time.Until(*expr.Deadline), func() {
+		aborted, err := abortExpression(db, id, models.StatusTimedOut)
+		if err != nil {
+			log.Printf("Ошибка записи статуса выражения %s: %v", id, err)
+			return
+		}
+		if aborted {
+			log.Printf("Срок выражения %s истёк, задачи отменены", id)
+		}
+	})
+}
+
+// Порядок выдачи задач: сначала больший приоритет, при равном -
+// более ранний срок; задачи без срока идут после задач со сроком
+func moreUrgent(a, b *models.Task) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Deadline.IsZero() || b.Deadline.IsZero() {
+		return !a.Deadline.IsZero() && b.Deadline.IsZero()
+	}
+	return a.Deadline.Before(b.Deadline)
+}
@@ -0,0 +1,251 @@
+package orchestrator
+
+import (
+	"calc/database"
+	"calc/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Интервал комментариев-пингов, чтобы прокси не закрывали молчащий поток
+const eventsPingInterval = 15 * time.Second
+
+// Подписка на события выражений пользователя; expressionID пустой -
+// события всех выражений
+type subscriber struct {
+	userID       string
+	expressionID string
+	events       chan eventMessage
+}
+
+type eventMessage struct {
+	kind  string // status или progress
+	event models.ExpressionEvent
+}
+
+var (
+	subscribers   = make(map[*subscriber]struct{})
+	subscribersMu sync.Mutex
+)
+
+func subscribe(userID, expressionID string) *subscriber {
+	sub := &subscriber{userID: userID, expressionID: expressionID, events: make(chan eventMessage, 64)}
+	subscribersMu.Lock()
+	subscribers[sub] = struct{}{}
+	subscribersMu.Unlock()
+	return sub
+}
+
+func unsubscribe(sub *subscriber) {
+	subscribersMu.Lock()
+	delete(subscribers, sub)
+	subscribersMu.Unlock()
+}
+
+func hasSubscribers() bool {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	return len(subscribers) > 0
+}
+
+// Рассылаем событие подписчикам владельца выражения. Если клиент не успевает
+// читать поток, событие для него пропускается: следующее всё равно несёт
+// актуальный статус и прогресс
+func publish(userID string, msg eventMessage) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	for sub := range subscribers {
+		if sub.userID != userID || (sub.expressionID != "" && sub.expressionID != msg.event.Id) {
+			continue
+		}
+		select {
+		case sub.events <- msg:
+		default:
+		}
+	}
+}
+
+// Сообщаем подписчикам, что у выражения сменился статус
+func notifyStatus(db *sql.DB, id string) {
+	notifyExpression(db, id, "status")
+}
+
+// Сообщаем подписчикам, что выполнена очередная задача выражения
+func notifyProgress(db *sql.DB, id string) {
+	notifyExpression(db, id, "progress")
+}
+
+func notifyExpression(db *sql.DB, id string, kind string) {
+	if !hasSubscribers() {
+		return
+	}
+
+	expr, err := database.GetExpression(db, id)
+	if err != nil || expr == nil {
+		log.Printf("Ошибка чтения выражения %s для уведомления: %v", id, err)
+		return
+	}
+	event, err := expressionEvent(expr)
+	if err != nil {
+		log.Printf("Ошибка подсчёта задач выражения %s: %v", id, err)
+		return
+	}
+	publish(expr.UserID, eventMessage{kind: kind, event: event})
+}
+
+// Состояние выражения и доля выполненных задач
+func expressionEvent(expr *models.Expression) (models.ExpressionEvent, error) {
+	event := models.ExpressionEvent{
+		Id:         expr.Id,
+		Status:     expr.Status,
+		Result:     expr.Result,
+		ResultText: expr.ResultText,
+		ErrorCode:  expr.ErrorCode,
+		Error:      expr.Error,
+	}
+
+	done, total, err := database.TaskProgress(taskDB, expr.Id)
+	if err != nil {
+		return event, err
+	}
+	event.TasksDone, event.TasksTotal = done, total
+
+	switch {
+	case expr.Status == models.StatusDone:
+		// Выражение могло посчитаться без задач или из кэша
+		event.Progress = 100
+	case total > 0:
+		event.Progress = math.Round(float64(done)*1000/float64(total)) / 10
+	}
+	return event, nil
+}
+
+// Поток не нужен после итогового статуса выражения
+func finalStatus(status string) bool {
+	return status != models.StatusPending && status != models.StatusRunning
+}
+
+// Поток событий по всем выражениям пользователя
+func ExpressionsEventsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	userID, err := requestUserID(r)
+	if err != nil {
+		http.Error(w, "невалидные данные", http.StatusUnauthorized)
+		return
+	}
+
+	// Подписываемся до чтения состояния, чтобы не пропустить изменения между ними
+	sub := subscribe(userID, "")
+	defer unsubscribe(sub)
+
+	expressions, err := database.GetExpressionsByUser(db, userID)
+	if err != nil {
+		http.Error(w, "что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	// Сначала присылаем текущее состояние невычисленных выражений
+	var snapshot []*models.Expression
+	for i := range expressions {
+		if !finalStatus(expressions[i].Status) {
+			snapshot = append(snapshot, &expressions[i])
+		}
+	}
+	streamEvents(w, r, sub, snapshot, false)
+}
+
+// Поток событий одного выражения, закрывается после итогового статуса
+func ExpressionEventsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	id := chi.URLParam(r, "id")
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		http.Error(w, "невалидные данные", http.StatusUnauthorized)
+		return
+	}
+
+	sub := subscribe(userID, id)
+	defer unsubscribe(sub)
+
+	expr, err := database.GetExpressionByID(db, id, userID)
+	if err != nil {
+		http.Error(w, "что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+	if expr == nil {
+		http.Error(w, "выражение не найдено", http.StatusNotFound)
+		return
+	}
+	streamEvents(w, r, sub, []*models.Expression{expr}, true)
+}
+
+// Пишем события в формате Server-Sent Events: сначала текущее состояние
+// выражений, затем изменения, пока клиент не отключится
+func streamEvents(w http.ResponseWriter, r *http.Request, sub *subscriber, snapshot []*models.Expression, single bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, expr := range snapshot {
+		event, err := expressionEvent(expr)
+		if err != nil {
+			log.Printf("Ошибка подсчёта задач выражения %s: %v", expr.Id, err)
+			continue
+		}
+		if err := writeEvent(w, eventMessage{kind: "status", event: event}); err != nil {
+			return
+		}
+		if single && finalStatus(event.Status) {
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case msg := <-sub.events:
+			if err := writeEvent(w, msg); err != nil {
+				return
+			}
+			if single && finalStatus(msg.event.Status) {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, msg eventMessage) error {
+	data, err := json.Marshal(msg.event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.kind, data)
+	return err
+}
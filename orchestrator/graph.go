@@ -0,0 +1,152 @@
+package orchestrator
+
+import (
+	"calc/database"
+	"calc/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Граф задач выражения: как выражение разбито на задачи и какие из них
+// ещё не посчитаны
+type taskGraph struct {
+	ExpressionID string         `json:"expression_id"`
+	Status       string         `json:"status"`
+	Tasks        []*models.Task `json:"tasks"`
+}
+
+// Цвет задачи в DOT по статусу
+var dotColors = map[string]string{
+	models.TaskWaiting:   "white",
+	models.TaskQueued:    "lightblue",
+	models.TaskRunning:   "gold",
+	models.TaskDone:      "palegreen",
+	models.TaskFailed:    "salmon",
+	models.TaskCancelled: "lightgray",
+}
+
+// Задачи выражения со статусами, агентами, временем и результатами.
+// ?format=dot возвращает граф в формате Graphviz
+func GetExpressionTasksHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	id := chi.URLParam(r, "id")
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		http.Error(w, fmt.Sprintf("неизвестный формат %q", format), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		http.Error(w, "невалидные данные", http.StatusUnauthorized)
+		return
+	}
+
+	expr, err := database.GetExpressionByID(db, id, userID)
+	if err != nil {
+		http.Error(w, "что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+	if expr == nil {
+		http.Error(w, "выражение не найдено", http.StatusNotFound)
+		return
+	}
+
+	tasks, err := database.LoadTasks(taskDB, []string{id})
+	if err != nil {
+		http.Error(w, "что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+	sortTasksByID(tasks)
+	assignCriticalPaths(tasks)
+
+	graph := taskGraph{ExpressionID: id, Status: expr.Status, Tasks: tasks}
+	if graph.Tasks == nil {
+		graph.Tasks = []*models.Task{}
+	}
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		fmt.Fprint(w, renderDOT(graph))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph)
+}
+
+// Задачи в порядке создания
+func sortTasksByID(tasks []*models.Task) {
+	sort.Slice(tasks, func(i, j int) bool {
+		a, _ := strconv.Atoi(tasks[i].Id)
+		b, _ := strconv.Atoi(tasks[j].Id)
+		return a < b
+	})
+}
+
+// Граф в формате DOT: стрелка ведёт от задачи к задаче, которой нужен её результат
+func renderDOT(graph taskGraph) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", "expression "+graph.ExpressionID)
+	b.WriteString("  rankdir=BT;\n")
+	b.WriteString("  node [shape=box, style=filled, fontname=\"monospace\"];\n")
+
+	for _, task := range graph.Tasks {
+		operands := make([]string, len(task.Operands))
+		for i, operand := range task.Operands {
+			operands[i] = operandLabel(operand)
+		}
+
+		lines := []string{
+			fmt.Sprintf("#%s %s(%s)", task.Id, task.Operation, strings.Join(operands, ", ")),
+			task.Status,
+		}
+		switch task.Status {
+		case models.TaskDone:
+			lines = append(lines, "= "+resultLabel(task))
+		case models.TaskFailed:
+			lines = append(lines, task.ErrorCode)
+		}
+		if task.AgentID != "" {
+			lines = append(lines, "агент "+task.AgentID)
+		}
+
+		attrs := fmt.Sprintf("label=%q, fillcolor=%q", strings.Join(lines, "\n"), dotColors[task.Status])
+		if task.IsFinal {
+			attrs += ", peripheries=2"
+		}
+		fmt.Fprintf(&b, "  %q [%s];\n", task.Id, attrs)
+	}
+
+	for _, task := range graph.Tasks {
+		for _, depID := range task.Dependencies {
+			fmt.Fprintf(&b, "  %q -> %q;\n", depID, task.Id)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Число или ссылка на задачу, результат которой ещё не подставлен
+func operandLabel(operand models.Operand) string {
+	if !operand.Ready() {
+		return "#" + operand.Ref
+	}
+	if operand.Text != "" {
+		return operand.Text
+	}
+	return strconv.FormatFloat(operand.Value, 'g', -1, 64)
+}
+
+func resultLabel(task *models.Task) string {
+	if task.DecimalResult != "" {
+		return task.DecimalResult
+	}
+	return strconv.FormatFloat(task.Result, 'g', -1, 64)
+}
@@ -0,0 +1,162 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"calc/taskrpc"
+	"database/sql"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC-сервис выдачи задач, работает с той же очередью, что и /internal/task
+type TaskServer struct {
+	DB *sql.DB // база выражений
+}
+
+// Агенты, подключённые по gRPC: ID агента -> канал ID задач, которые нужно бросить
+var (
+	grpcDrops   = make(map[string]chan string)
+	grpcDropsMu sync.Mutex
+)
+
+// Сообщаем агенту, что задача отменена. HTTP-агенты узнают об этом
+// по ответу 404 на продление аренды, gRPC-агентам отправляем drop сразу
+func dropLease(agentID string, taskID string) {
+	grpcDropsMu.Lock()
+	defer grpcDropsMu.Unlock()
+
+	if drops, ok := grpcDrops[agentID]; ok {
+		select {
+		case drops <- taskID:
+		default:
+		}
+	}
+}
+
+// Запускаем gRPC-сервер на addr
+func ServeGRPC(addr string, db *sql.DB) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	taskrpc.Register(server, &TaskServer{DB: db})
+	// Описание сервиса для grpcurl и других клиентов без .proto
+	reflection.Register(server)
+	return server.Serve(listener)
+}
+
+// Поток одного агента: первым сообщением агент регистрируется, дальше
+// запрашивает задачи, продлевает аренду и присылает результаты
+func (s *TaskServer) Connect(stream taskrpc.ConnectServer) error {
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Register == nil {
+		return status.Error(codes.InvalidArgument, "первым сообщением агент должен зарегистрироваться")
+	}
+	agentID := registerAgent(*first.Register)
+	operations := agentOperations(agentID)
+
+	var sendMu sync.Mutex
+	send := func(m *taskrpc.ServerMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(m)
+	}
+	if err := send(&taskrpc.ServerMessage{AgentID: agentID}); err != nil {
+		return err
+	}
+
+	drops := make(chan string, 64)
+	grpcDropsMu.Lock()
+	grpcDrops[agentID] = drops
+	grpcDropsMu.Unlock()
+	defer func() {
+		grpcDropsMu.Lock()
+		if grpcDrops[agentID] == drops {
+			delete(grpcDrops, agentID)
+		}
+		grpcDropsMu.Unlock()
+	}()
+	go func() {
+		for {
+			select {
+			case taskID := <-drops:
+				if send(&taskrpc.ServerMessage{Drop: taskID}) != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Каждая запрошенная задача - одно значение в канале
+	credits := make(chan struct{}, 1024)
+	go func() {
+		for {
+			select {
+			case <-credits:
+			case <-ctx.Done():
+				return
+			}
+
+			var tasks []*models.Task
+			for len(tasks) == 0 {
+				if ctx.Err() != nil {
+					return
+				}
+				tasks = waitTasks(ctx, agentID, operations, maxTaskWait, 1)
+			}
+			// Если отправить не удалось, задача вернётся в очередь по истечении аренды
+			if send(&taskrpc.ServerMessage{Task: tasks[0]}) != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			// Агент закрыл свою сторону потока, задачи ему больше не нужны
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		touchAgent(agentID)
+
+		if m.Result != nil {
+			if _, err := submitResult(s.DB, *m.Result); err != nil {
+				log.Printf("Ошибка записи результата задачи %s: %v", m.Result.Id, err)
+			}
+		}
+		for i := 0; i < m.Request; i++ {
+			select {
+			case credits <- struct{}{}:
+			default:
+			}
+		}
+		if m.Lease != "" {
+			reply := &taskrpc.ServerMessage{Drop: m.Lease}
+			if lease, ok := extendLease(m.Lease); ok {
+				reply = &taskrpc.ServerMessage{Lease: &lease}
+			}
+			if err := send(reply); err != nil {
+				return err
+			}
+		}
+	}
+}
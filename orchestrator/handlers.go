@@ -13,27 +13,63 @@ import (
     "database/sql"
     "golang.org/x/crypto/bcrypt"
     "strings"
+    "slices"
+    "strconv"
+    "context"
+    "crypto/subtle"
 )
 
+// Максимальное время ожидания задачи в long-polling запросе
+const maxTaskWait = 60 * time.Second
+
+// Максимальное число задач, которое агент может взять одним запросом
+const maxTaskBatch = 100
+
 func GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	// Зарегистрированный агент получает только задачи с операциями, которые он умеет выполнять
+	agentID := r.URL.Query().Get("agent_id")
+	var operations []string
+	if agentID != "" && touchAgent(agentID) {
+		operations = agentOperations(agentID)
+	}
+
+	// wait - сколько секунд ждать появления задачи, если очередь пуста
+	var wait time.Duration
+	if val := r.URL.Query().Get("wait"); val != "" {
+		seconds, err := strconv.ParseFloat(val, 64)
+		if err != nil || seconds < 0 {
+			http.Error(w, "невалидные данные", http.StatusBadRequest)
+			return
+		}
+		wait = min(time.Duration(seconds*float64(time.Second)), maxTaskWait)
+	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	// max - взять до max задач сразу, ответ тогда содержит список задач
+	limit := 1
+	batch := r.URL.Query().Has("max")
+	if batch {
+		n, err := strconv.Atoi(r.URL.Query().Get("max"))
+		if err != nil || n < 1 {
+			http.Error(w, "невалидные данные", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxTaskBatch)
+	}
 
-	// Проверяем, что в очереди есть задачи
-	if len(TaskQueue) == 0 {
+	tasks := waitTasks(r.Context(), agentID, operations, wait, limit)
+	if len(tasks) == 0 {
 		http.Error(w, "нет доступных задач", http.StatusNotFound)
 		return
 	}
 
-	// Получаем первую задачу из очереди
-	task := TaskQueue[0]
-	TaskQueue = TaskQueue[1:]
-
 	// Отправляем задачу агенту
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(task); err != nil {
+	var body interface{} = tasks[0]
+	if batch {
+		body = models.TaskBatch{Tasks: tasks}
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		http.Error(w, fmt.Sprintf("ошибка при отправке задачи: %v", err), http.StatusInternalServerError)
 	}
 
@@ -41,19 +77,80 @@ func GetTaskHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// Берём до limit подходящих задач из очереди, а если их нет - ждём,
+// пока задачи появятся, истечёт wait или агент отключится
+func waitTasks(ctx context.Context, agentID string, operations []string, wait time.Duration, limit int) []*models.Task {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		tasks, ready := takeTasks(agentID, operations, limit)
+		if len(tasks) > 0 {
+			return tasks
+		}
+
+		select {
+		case <-ready:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Выдаём агенту в аренду до limit подходящих задач из очереди. Если задач
+// нет, возвращаем канал, который закроется при появлении новых
+func takeTasks(agentID string, operations []string, limit int) ([]*models.Task, <-chan struct{}) {
+	TaskMutex.Lock()
+	defer TaskMutex.Unlock()
+
+	accept := func(task *models.Task) bool {
+		return len(operations) == 0 || slices.Contains(operations, task.Operation)
+	}
+	running := runningByUser()
+
+	var tasks []*models.Task
+	for len(tasks) < limit {
+		task := queue.take(accept, running)
+		if task == nil {
+			break
+		}
+		grantLease(task, agentID)
+		running[task.UserID]++
+		// Отдаём копию, чтобы не читать задачу без блокировки при отправке
+		leased := *task
+		tasks = append(tasks, &leased)
+	}
+	return tasks, TaskReady
+}
+
+
 func PostTaskResultHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// log.Println("Получен POST запрос для записи результата")
 
-	var taskResult models.Responce2
-	if err := json.NewDecoder(r.Body).Decode(&taskResult); err != nil {
+	// Тело - либо результат одной задачи, либо пакет {"results": [...]}
+	var body struct {
+		models.Responce2
+		Results []models.Responce2 `json:"results"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "невалидные данные", http.StatusUnprocessableEntity)
 		return
 	}
 
-	// Обновляем в БД
-	err := UpdateExpressionResultAndStatus(db, taskResult.Id, taskResult.Result, "завершено")
+	if body.Results != nil {
+		postResultBatch(w, db, body.Results)
+		return
+	}
+
+	ok, err := submitResult(db, body.Responce2)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("что-то пошло не так"), http.StatusInternalServerError)
+		http.Error(w, "что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "задача не найдена", http.StatusNotFound)
 		return
 	}
 
@@ -61,17 +158,110 @@ func PostTaskResultHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	w.Write([]byte("результат успешно записан"))
 }
 
-func UpdateExpressionResultAndStatus(db *sql.DB, id string, result float64, status string) error {
-	res, err := db.Exec(`UPDATE expressions SET result = ?, status = ? WHERE id = ?`, result, status, id)
+// Принимаем пакет результатов: каждый результат обрабатывается отдельно,
+// в ответе для каждой задачи указано, принят ли её результат
+func postResultBatch(w http.ResponseWriter, db *sql.DB, results []models.Responce2) {
+	var response struct {
+		Results []models.ResultStatus `json:"results"`
+	}
+	response.Results = make([]models.ResultStatus, 0, len(results))
+
+	for _, result := range results {
+		status := models.ResultStatus{Id: result.Id, Status: "accepted"}
+		ok, err := submitResult(db, result)
+		switch {
+		case err != nil:
+			status.Status = "error"
+			status.Error = err.Error()
+		case !ok:
+			status.Status = "not_found"
+			status.Error = "задача не найдена"
+		}
+		response.Results = append(response.Results, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Принимаем результат задачи от агента. Результат принимается только
+// для известной и ещё не выполненной задачи, иначе возвращается false
+func submitResult(db *sql.DB, result models.Responce2) (bool, error) {
+	task, finished, ok := completeTask(result)
+	if !ok {
+		return false, nil
+	}
+	// Зависимые задачи вплоть до финальной нашлись в кэше
+	if finished != nil {
+		task = finished
+	}
+
+	// Финальная задача даёт результат всего выражения, а задача с ошибкой -
+	// статус error; оставшиеся задачи выражения снимаются с очереди
+	if task.IsFinal || task.Status == models.TaskFailed {
+		if err := finishExpression(db, task); err != nil {
+			return true, err
+		}
+		return true, nil
+	}
+	notifyProgress(db, task.ExpressionID)
+	return true, nil
+}
+
+func UpdateExpressionResultAndStatus(db *sql.DB, id string, result float64, resultText string, status string) error {
+	// Точный результат пишем только в десятичном режиме
+	var text sql.NullString
+	if resultText != "" {
+		text = sql.NullString{String: resultText, Valid: true}
+	}
+
+	// Выражение, которое уже завершилось ошибкой или по сроку, не перезаписываем
+	res, err := db.Exec(`UPDATE expressions SET result = ?, result_text = ?, status = ? WHERE id = ? AND status IN (?, ?)`,
+		result, text, status, id, models.StatusPending, models.StatusRunning)
 	if err != nil {
 		return err
 	}
 
 	rowsAffected, _ := res.RowsAffected()
 	log.Printf("обновлено строк: %d", rowsAffected)
+	if rowsAffected > 0 {
+		notifyStatus(db, id)
+	}
 	return nil
 }
 
+// Записываем ошибку вычисления: статус error, код и текст причины
+func UpdateExpressionError(db *sql.DB, id string, code string, message string) error {
+	res, err := db.Exec(`UPDATE expressions SET status = ?, error_code = ?, error = ?, result = NULL, result_text = NULL WHERE id = ? AND status IN (?, ?)`,
+		models.StatusError, code, message, id, models.StatusPending, models.StatusRunning)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		notifyStatus(db, id)
+	}
+	return nil
+}
+
+// Прерываем невычисленное выражение: ставим итоговый статус и снимаем
+// оставшиеся задачи. false - выражение уже завершилось
+func abortExpression(db *sql.DB, id string, status string) (bool, error) {
+	res, err := db.Exec(`UPDATE expressions SET status = ? WHERE id = ? AND status IN (?, ?)`,
+		status, id, models.StatusPending, models.StatusRunning)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	storeExpressionResult(id, nil)
+	dropExpressionTasks(id)
+	notifyStatus(db, id)
+	return true, nil
+}
+
 
 
 func GetExpressionsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
@@ -107,29 +297,12 @@ func GetExpressionsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 
 	// 4. Выполняем запрос с фильтрацией по user_id
-	rows, err := db.Query(`SELECT id, status, result FROM expressions WHERE user_id = ?`, userID)
+	expressions, err := database.GetExpressionsByUser(db, userID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("ошибка при получении данных: %v", err), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var expr models.Expression
-		var result sql.NullFloat64
-
-		if err := rows.Scan(&expr.Id, &expr.Status, &result); err != nil {
-			http.Error(w, fmt.Sprintf("ошибка при сканировании данных: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Если результат не null — присваиваем, иначе 0.0 (или пропускаем)
-		if result.Valid {
-			expr.Result = result.Float64
-		}
-
-		response.Expressions = append(response.Expressions, expr)
-	}
+	response.Expressions = expressions
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "ошибка при отправке данных", http.StatusInternalServerError)
@@ -182,6 +355,88 @@ func GetExpressionByID(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 
 
+// Отмена выражения: задачи снимаются с очереди, агенты, которые их считают,
+// получают отказ при продлении аренды, а их поздние результаты не принимаются
+func DeleteExpressionHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	id := chi.URLParam(r, "id")
+
+	userID, err := requestUserID(r)
+	if err != nil {
+		http.Error(w, "невалидные данные", http.StatusUnauthorized)
+		return
+	}
+
+	expr, err := database.GetExpressionByID(db, id, userID)
+	if err != nil {
+		http.Error(w, "что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+	if expr == nil {
+		http.Error(w, "выражение не найдено", http.StatusNotFound)
+		return
+	}
+
+	cancelled, err := abortExpression(db, id, models.StatusCancelled)
+	if err != nil {
+		http.Error(w, "что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "выражение уже завершено", http.StatusConflict)
+		return
+	}
+	log.Printf("Выражение %s отменено пользователем", id)
+
+	expr.Status = models.StatusCancelled
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(expr)
+}
+
+// Достаём user_id из JWT в заголовке Authorization
+func requestUserID(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", fmt.Errorf("отсутствует токен авторизации")
+	}
+	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return database.JwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("невалидный токен")
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return "", fmt.Errorf("невалидный токен")
+	}
+	return userID, nil
+}
+
+
+
+// Доступ к /admin/*: в заголовке Authorization должен быть токен
+// администратора. Без токена (ADMIN_TOKEN не задан) эндпоинты выключены,
+// ведь они показывают выражения и агентов всех пользователей
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.Error(w, "администрирование выключено: задайте ADMIN_TOKEN", http.StatusForbidden)
+				return
+			}
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "невалидный токен администратора", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // Структура для данных регистрации
 type RegisterRequest struct {
 	Login    string `json:"login"`
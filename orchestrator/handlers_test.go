@@ -0,0 +1,41 @@
+package orchestrator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"токен не задан", "", "Bearer secret", http.StatusForbidden},
+		{"токен не задан, пустой заголовок", "", "", http.StatusForbidden},
+		{"без заголовка", "secret", "", http.StatusUnauthorized},
+		{"неверный токен", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"токен без Bearer", "secret", "secret", http.StatusOK},
+		{"верный токен", "secret", "Bearer secret", http.StatusOK},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/queues", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			AdminAuth(tt.token)(ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("статус %d, ожидалось %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
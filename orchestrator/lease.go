@@ -0,0 +1,106 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func leaseDuration() time.Duration {
+	return time.Duration(models.TLease) * time.Millisecond
+}
+
+// Выдаём задачу агенту в аренду. Вызывается под TaskMutex
+func grantLease(task *models.Task, agentID string) {
+	task.Status = models.TaskRunning
+	task.AgentID = agentID
+	task.Attempts++
+	task.StartedAt = time.Now()
+	task.LeaseDeadline = task.StartedAt.Add(leaseDuration())
+	saveTask(task)
+}
+
+// Агент продлевает аренду задачи, которую ещё считает.
+// 404 означает, что задача больше не за ним и её нужно бросить
+func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	resp, ok := extendLease(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, "задача не найдена", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Продлеваем аренду выданной задачи; false - задача уже не выполняется
+func extendLease(id string) (models.LeaseResponse, bool) {
+	TaskMutex.Lock()
+	defer TaskMutex.Unlock()
+
+	task, ok := Tasks[id]
+	if !ok || task.Status != models.TaskRunning {
+		return models.LeaseResponse{}, false
+	}
+	task.LeaseDeadline = time.Now().Add(leaseDuration())
+	saveTask(task)
+	return models.LeaseResponse{Id: task.Id, LeaseDeadline: task.LeaseDeadline}, true
+}
+
+// Периодически возвращаем в очередь задачи с истёкшей арендой
+func WatchLeases(db *sql.DB) {
+	interval := leaseDuration() / 4
+	if interval < 50*time.Millisecond {
+		interval = 50 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expireLeases(db, time.Now())
+	}
+}
+
+func expireLeases(db *sql.DB, now time.Time) {
+	var failed []*models.Task
+
+	TaskMutex.Lock()
+	for _, task := range Tasks {
+		if task.Status != models.TaskRunning || now.Before(task.LeaseDeadline) {
+			continue
+		}
+
+		task.LeaseDeadline = time.Time{}
+		countAgentResult(task.AgentID, true)
+		if task.Attempts >= models.MaxAttempts {
+			task.Status = models.TaskFailed
+			task.FinishedAt = now
+			task.ErrorCode = models.ErrAttemptsExceeded
+			task.Error = fmt.Sprintf("задача %s не выполнена, число попыток: %d", task.Id, task.Attempts)
+			saveTask(task)
+			failed = append(failed, task)
+			continue
+		}
+
+		// Повторную попытку выдаём раньше новых задач
+		log.Printf("Аренда задачи %s истекла (попытка %d), задача возвращена в очередь", task.Id, task.Attempts)
+		task.Status = models.TaskQueued
+		saveTask(task)
+		queue.push(task, true)
+		notifyTaskReady()
+	}
+	TaskMutex.Unlock()
+
+	for _, task := range failed {
+		log.Printf("Выражение %s завершено ошибкой: %s", task.ExpressionID, task.Error)
+		if err := finishExpression(db, task); err != nil {
+			log.Printf("Ошибка записи статуса выражения %s: %v", task.ExpressionID, err)
+		}
+	}
+}
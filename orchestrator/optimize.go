@@ -0,0 +1,266 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"math"
+)
+
+// Упрощаем дерево выражения перед созданием задач:
+//   - убираем операции, которые не меняют значение: x+0, x*1, x/1, x^1, -(-x);
+//   - если включена свёртка (models.FoldMaxCost > 0), дешёвые операции над числами
+//     считаем сразу; при reorder - и числа из разных мест цепочки + или *: 1+x+2 = 3+x.
+//
+// Без reorder скобки и порядок вычисления остаются записанными, чтобы результат
+// с плавающей точкой не зависел от оптимизации: сворачиваются только числа,
+// которые и так считаются первыми, (1+2)+x = 3+x.
+// В десятичном режиме результат каждой задачи округляется, поэтому там
+// убираются только тождественные операции над результатами задач.
+// Ошибки вычисления (деление на ноль и т.п.) не сворачиваются и остаются в задачах
+func optimizeTree(node *models.ASTNode, precision *models.Precision, reorder bool) *models.ASTNode {
+	if node == nil || node.IsLeaf {
+		return node
+	}
+	exact := precision == nil
+
+	if exact && reorder && (node.Operator == "+" || node.Operator == "*") && node.Right != nil {
+		return optimizeChain(node)
+	}
+
+	if node.Left != nil {
+		node.Left = optimizeTree(node.Left, precision, reorder)
+	}
+	if node.Right != nil {
+		node.Right = optimizeTree(node.Right, precision, reorder)
+	}
+	for i, arg := range node.Args {
+		node.Args[i] = optimizeTree(arg, precision, reorder)
+	}
+
+	// Тождественная операция: узел заменяется своим операндом. В десятичном
+	// режиме число-лист не округлено, поэтому оставляем операцию агенту
+	if operand := identityOperand(node); operand != nil && (exact || !operand.IsLeaf) {
+		return operand
+	}
+
+	if exact {
+		foldConstant(node)
+	}
+	return node
+}
+
+// Операнд, которым можно заменить узел, или nil
+func identityOperand(node *models.ASTNode) *models.ASTNode {
+	switch node.Operator {
+	case "+":
+		if isConstant(node.Left, 0) {
+			return node.Right
+		}
+		if isConstant(node.Right, 0) {
+			return node.Left
+		}
+	case "-":
+		if isConstant(node.Right, 0) {
+			return node.Left
+		}
+	case "*":
+		if isConstant(node.Left, 1) {
+			return node.Right
+		}
+		if isConstant(node.Right, 1) {
+			return node.Left
+		}
+	case "/", "^":
+		if isConstant(node.Right, 1) {
+			return node.Left
+		}
+	case "u+":
+		return node.Left
+	case "u-":
+		if node.Left.Operator == "u-" && !node.Left.IsLeaf {
+			return node.Left.Left
+		}
+	}
+	return nil
+}
+
+func isConstant(n *models.ASTNode, value float64) bool {
+	return n != nil && n.IsLeaf && n.Value == value
+}
+
+// Цепочку одинаковых операций a+b+c+... раскладываем на операнды, числа
+// сворачиваем в одно и собираем цепочку заново, слева направо. Скобки внутри
+// цепочки при этом теряются, поэтому только вместе с перестройкой дерева
+// и только если из цепочки что-то убрано или свёрнуто
+func optimizeChain(node *models.ASTNode) *models.ASTNode {
+	var operands []*models.ASTNode
+	changed := false
+	var collect func(n *models.ASTNode, optimized bool)
+	collect = func(n *models.ASTNode, optimized bool) {
+		if isChain(n, node.Operator) {
+			collect(n.Left, optimized)
+			collect(n.Right, optimized)
+			return
+		}
+		if optimized {
+			operands = append(operands, n)
+			return
+		}
+		// После упрощения операнд сам может оказаться такой же цепочкой: (a+b)*1
+		operand := optimizeTree(n, nil, true)
+		if isChain(operand, node.Operator) {
+			changed = true
+		}
+		collect(operand, true)
+	}
+	collect(node, false)
+
+	// Нейтральные числа (0 для +, 1 для *) убираем, остальные числа
+	// сворачиваем, только если операция дешёвая
+	identity := 0.0
+	if node.Operator == "*" {
+		identity = 1
+	}
+	var rest []*models.ASTNode
+	var constants []float64
+	for _, operand := range operands {
+		if isConstant(operand, identity) && len(operands) > 1 {
+			changed = true
+			continue
+		}
+		if operand.IsLeaf && foldable(node.Operator) {
+			constants = append(constants, operand.Value)
+			continue
+		}
+		rest = append(rest, operand)
+	}
+
+	// Свёрнутые числа ставим в начало цепочки
+	if len(constants) > 0 {
+		var leaves []*models.ASTNode
+		folded, ok := foldConstants(node.Operator, constants)
+		if !ok {
+			// Переполнение - ошибка, которую должен получить агент
+			for _, value := range constants {
+				leaves = append(leaves, &models.ASTNode{Value: value, IsLeaf: true, Column: node.Column})
+			}
+		} else if folded != identity || len(rest) == 0 {
+			leaves = append(leaves, &models.ASTNode{Value: folded, IsLeaf: true, Column: node.Column})
+		}
+		if ok && len(constants) > 1 {
+			changed = true
+		}
+		rest = append(leaves, rest...)
+	}
+
+	// Ничего не убрано и не свёрнуто: оставляем скобки как были,
+	// подставив упрощённые операнды на их места
+	if !changed {
+		next := 0
+		var replace func(n *models.ASTNode) *models.ASTNode
+		replace = func(n *models.ASTNode) *models.ASTNode {
+			if isChain(n, node.Operator) {
+				n.Left = replace(n.Left)
+				n.Right = replace(n.Right)
+				return n
+			}
+			operand := operands[next]
+			next++
+			return operand
+		}
+		return replace(node)
+	}
+
+	// Цепочка из одних нейтральных чисел: 0+0
+	if len(rest) == 0 {
+		return &models.ASTNode{Value: identity, IsLeaf: true, Column: node.Column}
+	}
+	chain := rest[0]
+	for _, operand := range rest[1:] {
+		chain = &models.ASTNode{Operator: node.Operator, Left: chain, Right: operand, Column: node.Column}
+	}
+	return chain
+}
+
+// Узел - бинарная операция op, то есть часть цепочки
+func isChain(n *models.ASTNode, op string) bool {
+	return n.Operator == op && !n.IsLeaf && n.Right != nil
+}
+
+func foldConstants(op string, values []float64) (float64, bool) {
+	result := values[0]
+	for _, value := range values[1:] {
+		var ok bool
+		if result, ok = evaluate(op, []float64{result, value}); !ok {
+			return 0, false
+		}
+	}
+	return result, true
+}
+
+// Считаем операцию над числами сразу, если она дешёвая и не даёт ошибки
+func foldConstant(node *models.ASTNode) {
+	if !foldable(node.Operator) {
+		return
+	}
+
+	var args []float64
+	for _, operand := range append([]*models.ASTNode{node.Left, node.Right}, node.Args...) {
+		if operand == nil {
+			continue
+		}
+		if !operand.IsLeaf {
+			return
+		}
+		args = append(args, operand.Value)
+	}
+
+	value, ok := evaluate(node.Operator, args)
+	if !ok {
+		return
+	}
+	*node = models.ASTNode{Value: value, IsLeaf: true, Column: node.Column}
+}
+
+// Свёртка включена и операция не дольше порога
+func foldable(op string) bool {
+	return models.FoldMaxCost > 0 && getOperationTime(op) <= models.FoldMaxCost
+}
+
+// Операции, которые оркестратор умеет считать так же, как агент.
+// false - операция не поддерживается или её результат - ошибка
+func evaluate(op string, args []float64) (float64, bool) {
+	var result float64
+	switch op {
+	case "+":
+		result = args[0] + args[1]
+	case "-":
+		result = args[0] - args[1]
+	case "*":
+		result = args[0] * args[1]
+	case "u-":
+		result = -args[0]
+	case "/":
+		if args[1] == 0 {
+			return 0, false
+		}
+		result = args[0] / args[1]
+	case "abs":
+		result = math.Abs(args[0])
+	case "min":
+		result = args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+	case "max":
+		result = args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+	default:
+		return 0, false
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, false
+	}
+	return result, true
+}
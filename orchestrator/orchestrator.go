@@ -7,14 +7,17 @@ import (
 	"strings"
 	"sync"
 	"github.com/google/uuid"
-	"unicode"
-	"regexp"
 	"fmt"
 	"database/sql"
 	"calc/database"
 	"github.com/golang-jwt/jwt/v5"
 	"log"
+	"calc/parser"
+	"calc/operations"
+	"errors"
+	"slices"
 	"strconv"
+	"time"
 )
 
 var(
@@ -23,14 +26,29 @@ var(
 
 	taskID    int
 	Tasks     = make(map[string]*models.Task)
-	TaskQueue []*models.Task
+	queue     = newScheduler() // только задачи, у которых выполнены все зависимости
 	TaskMutex sync.Mutex
 
-	TaskReady = make(chan bool, 1)
+	// Хранилище задач, см. InitTaskStore
+	taskDB *sql.DB
+
+	// Обратные зависимости: ID задачи -> ID задач, которые ждут её результат
+	dependents = make(map[string][]string)
+
+	// Выражения, для которых создаются задачи; true - выражение прервали
+	// за это время и задачи регистрировать не нужно
+	planning = make(map[string]bool)
+
+	// Закрывается, когда в очереди появляются задачи, и сразу заменяется новым
+	// каналом; ожидающие агенты берут текущий канал под TaskMutex
+	TaskReady = make(chan struct{})
 	ComputingPowerChannel = make(chan int)
 
 )
 
+// Максимальное число знаков после запятой в десятичном режиме
+const maxDecimalScale = 1000
+
 // Хендлер для вычислений
 func CalculateHandler(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
 	var input models.ExpressionInput
@@ -48,9 +66,42 @@ func CalculateHandler(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
 		return
 	}
 
-	// Проверяем корректность выражения
-	if !isValidExpression(input.Expression) {
-		http.Error(w, "невалидные данные", http.StatusUnprocessableEntity)
+	// Разбираем выражение, при ошибке сообщаем позицию и причину
+	// В десятичном режиме числа могут выходить за диапазон float64
+	parse := parser.Parse
+	if input.Precision != nil {
+		parse = parser.ParseDecimal
+	}
+	tree, err := parse(input.Expression)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+
+	// Все переменные выражения должны быть заданы до начала вычислений
+	if err := parser.Bind(tree, input.Variables); err != nil {
+		writeParseError(w, err)
+		return
+	}
+
+	// Проверяем параметры десятичного режима
+	if input.Precision != nil {
+		if input.Precision.Scale < 0 || input.Precision.Scale > maxDecimalScale {
+			http.Error(w, fmt.Sprintf("точность должна быть от 0 до %d знаков", maxDecimalScale), http.StatusUnprocessableEntity)
+			return
+		}
+		if input.Precision.Rounding == "" {
+			input.Precision.Rounding = "half_even"
+		}
+		if !slices.Contains(models.RoundingModes, input.Precision.Rounding) {
+			http.Error(w, fmt.Sprintf("неизвестный режим округления %q", input.Precision.Rounding), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// Срок должен быть в будущем
+	if input.Deadline != nil && !input.Deadline.After(time.Now()) {
+		http.Error(w, "срок вычисления уже прошёл", http.StatusUnprocessableEntity)
 		return
 	}
 
@@ -81,11 +132,24 @@ func CalculateHandler(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
 	id := uuid.New().String()
 
 	// Добавляем в БД
-	err = database.SaveExpressionForUser(dbConn, userID, id, cleaned)
+	input.Expression = cleaned
+	err = database.SaveExpressionForUser(dbConn, userID, id, input)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("ошибка сохранения выражения: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifyStatus(dbConn, id)
+
+	// Такое же выражение уже считалось: сразу записываем результат
+	key := expressionKey(input)
+	cached, hit := expressionCache.get(key)
+	if hit {
+		err = UpdateExpressionResultAndStatus(dbConn, id, cached.Result, cached.ResultText, models.StatusDone)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ошибка сохранения выражения: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// Отправляем ответ с ID
 	resp := models.Responce1{Id: id}
@@ -96,272 +160,341 @@ func CalculateHandler(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
 		return
 	}
 
+	if hit {
+		return
+	}
+
 	// Параллельно обрабатываем выражение
-	go ProcessExpression(dbConn, id, cleaned)
+	rememberExpressionKey(id, key)
+	expr := &models.Expression{
+		Id:        id,
+		UserID:    userID,
+		Precision: input.Precision,
+		Priority:  input.Priority,
+		Deadline:  input.Deadline,
+		Optimize:  input.OptimizeEnabled(),
+		Rebalance: input.RebalanceEnabled(),
+	}
+	scheduleDeadline(dbConn, expr)
+	go ProcessExpression(dbConn, expr, tree)
 }
 
+// Отправляем ошибку разбора в виде JSON с позицией и ожидаемыми токенами
+func writeParseError(w http.ResponseWriter, err error) {
+	resp := models.ErrorResponse{Error: "невалидные данные", Message: err.Error()}
 
+	var syntaxErr *parser.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		resp.Message = syntaxErr.Message
+		resp.Column = syntaxErr.Column
+		resp.Token = syntaxErr.Token
+		resp.Expected = syntaxErr.Expected
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(resp)
+}
 
 
-func ProcessExpression(db *sql.DB, id string, input string) {
-	// Обновляем статус в БД
-	if err := UpdateExpressionStatus(db, id, "выполняется"); err != nil {
-		// log.Printf("Ошибка обновления статуса выражения: %v", err)
-	}
 
-	rpn := convertToRPN(input)
-	tree := createExpressionTree(rpn)
 
-	if createTasksForTree(tree, id) {
-	}
-}
 
+func ProcessExpression(db *sql.DB, expr *models.Expression, tree *models.ASTNode) {
+	id, precision := expr.Id, expr.Precision
 
-func UpdateExpressionStatus(db *sql.DB, id string, status string) error {
-	_, err := db.Exec(`UPDATE expressions SET status = ? WHERE id = ?`, status, id)
-	return err
-}
+	// Отмена или срок, наступившие до регистрации задач, отмечаются в planning
+	TaskMutex.Lock()
+	planning[id] = false
+	TaskMutex.Unlock()
 
-func isValidExpression(expression string) bool {
-	openBrackets := 0
-	previousChar := rune(0)
+	// Обновляем статус в БД; выражение могли прервать раньше, чем для него созданы задачи
+	started, err := startExpression(db, id)
+	if err != nil {
+		log.Printf("Ошибка обновления статуса выражения %s: %v", id, err)
+	}
+	if err == nil && !started {
+		endPlanning(id)
+		return
+	}
 
-	// Проверяем, что выражение не пустое
-	if len(expression) == 0 {
-		return false
+	// Упрощаем дерево, чтобы не создавать задачи для тривиальных операций
+	if expr.Optimize {
+		tree = optimizeTree(tree, precision, expr.Rebalance)
 	}
+	// Длинные цепочки + и * считаем параллельно
+	if expr.Rebalance {
+		tree = rebalanceTree(tree, precision)
+	}
+	expr.Depth = treeDepth(tree)
 
-	for i, char := range expression {
-		// Пропускаем пробелы
-		if unicode.IsSpace(char) {
-			continue
+	// Создаём задачи; выражение из одного числа считать не нужно
+	finished, ok := createTasksForTree(tree, expr)
+	// Выражение прервали: задачи не зарегистрированы или уже сняты
+	if endPlanning(id) {
+		return
+	}
+	if ok {
+		if err := UpdateExpressionPlan(db, id, expr.MinMakespanMs, expr.Depth); err != nil {
+			log.Printf("Ошибка записи времени вычисления выражения %s: %v", id, err)
 		}
-
-		// Проверка на допустимые символы
-		if !strings.ContainsRune("0123456789+-*/().", char) {
-			return false
+		// Все задачи нашлись в кэше
+		if finished != nil {
+			if err := finishExpression(db, finished); err != nil {
+				log.Printf("Ошибка записи результата выражения %s: %v", id, err)
+			}
+		} else {
+			notifyProgress(db, id)
 		}
-
-		// Проверка на баланс скобок
-		if char == '(' {
-			openBrackets++
-
-			// Следующий символ не может быть оператором (кроме унарного минуса)
-			if i+1 < len(expression) {
-				next := rune(expression[i+1])
-				if strings.ContainsRune("*/+)", next) {
-					return false
+	} else {
+		result, resultText := tree.Value, ""
+		if precision != nil {
+			// Число округляем до точности выражения, как агент - результат задачи.
+			// Значение вне диапазона float64 остаётся только в тексте
+			text, err := operations.RoundDecimal(decimalOperand(tree), precision)
+			if err != nil {
+				code := models.ErrInvalidArgument
+				var taskErr *models.TaskError
+				if errors.As(err, &taskErr) {
+					code = taskErr.Code
 				}
+				if err := UpdateExpressionError(db, id, code, err.Error()); err != nil {
+					log.Printf("Ошибка записи статуса выражения %s: %v", id, err)
+				}
+				return
 			}
-
-			// Предыдущий символ не может быть цифрой или ')'
-			if previousChar != 0 && (unicode.IsDigit(previousChar) || previousChar == ')') {
-				return false
-			}
-		} else if char == ')' {
-			openBrackets--
-
-			// Скобки не сбалансированы
-			if openBrackets < 0 {
-				return false
-			}
-
-			// Предыдущий символ не может быть оператором или '('
-			if strings.ContainsRune("+-*/(", previousChar) {
-				return false
+			resultText, result = text, 0
+			if value, err := strconv.ParseFloat(text, 64); err == nil {
+				result = value
 			}
 		}
-
-		// Два подряд оператора (исключая унарный минус)
-		if strings.ContainsRune("+-*/", char) && strings.ContainsRune("+-*/", previousChar) {
-			// Разрешаем унарный минус после '('
-			if !(char == '-' && previousChar == '(') {
-				return false
-			}
-		}
-
-		// Проверка начала выражения
-		if i == 0 && (char == '*' || char == '/' || char == '+') {
-			return false
-		}
-
-		// Проверка конца выражения
-		if i == len(expression)-1 && strings.ContainsRune("+-*/(", char) {
-			return false
+		if err := UpdateExpressionResultAndStatus(db, id, result, resultText, models.StatusDone); err != nil {
+			log.Printf("Ошибка записи результата выражения %s: %v", id, err)
 		}
-
-		previousChar = char
+		storeExpressionResult(id, &cachedResult{Result: result, ResultText: resultText})
 	}
+}
 
-	// Проверка, что нет пустого выражения или только операторов
-	if previousChar == '+' || previousChar == '-' || previousChar == '*' || previousChar == '/' {
-		return false
-	}
 
-	// Проверка баланса скобок
-	return openBrackets == 0
+func UpdateExpressionStatus(db *sql.DB, id string, status string) error {
+	_, err := db.Exec(`UPDATE expressions SET status = ? WHERE id = ?`, status, id)
+	if err == nil {
+		notifyStatus(db, id)
+	}
+	return err
 }
 
+// Записываем минимальное время вычисления выражения и глубину дерева задач
+func UpdateExpressionPlan(db *sql.DB, id string, makespan float64, depth int) error {
+	_, err := db.Exec(`UPDATE expressions SET min_makespan_ms = ?, depth = ? WHERE id = ?`, makespan, depth, id)
+	return err
+}
 
-func convertToRPN(expression string) []string {
-	var output []string
-	var stack []string
-
-	// Регулярное выражение: числа, операторы, скобки
-	re := regexp.MustCompile(`(\d+\.?\d*|\+|\-|\*|\/|\(|\))`)
-	tokens := re.FindAllString(expression, -1)
+// Переводим выражение в статус "выполняется", если оно ещё не завершено
+func startExpression(db *sql.DB, id string) (bool, error) {
+	res, err := db.Exec(`UPDATE expressions SET status = ? WHERE id = ? AND status IN (?, ?)`,
+		models.StatusRunning, id, models.StatusPending, models.StatusRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if n > 0 {
+		notifyStatus(db, id)
+	}
+	return n > 0, err
+}
 
-	for i := 0; i < len(tokens); i++ {
-		token := tokens[i]
+// Задачи выражения созданы или выражение прервано; true - прервано
+func endPlanning(id string) bool {
+	TaskMutex.Lock()
+	defer TaskMutex.Unlock()
 
-		switch token {
-		case "+", "-":
-			// Обработка унарного минуса: если "-" в начале или после "(", оператора
-			if token == "-" && (i == 0 || tokens[i-1] == "(" || isOperator(tokens[i-1])) {
-				// Объединяем "-" и следующее число
-				if i+1 < len(tokens) && isNumber(tokens[i+1]) {
-					tokens[i+1] = "-" + tokens[i+1]
-					continue // пропускаем текущий унарный минус
-				}
-			}
+	aborted := planning[id]
+	delete(planning, id)
+	return aborted
+}
 
-			// Обычные операторы + и -
-			for len(stack) > 0 && precedence(stack[len(stack)-1]) >= precedence(token) {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = append(stack, token)
+// Убираем из очереди задачи выражения, которые больше не нужно выполнять,
+// невыполненные задачи помечаются отменёнными
+func dropExpressionTasks(expressionID string) {
+	TaskMutex.Lock()
+	defer TaskMutex.Unlock()
 
-		case "*", "/":
-			for len(stack) > 0 && precedence(stack[len(stack)-1]) >= precedence(token) {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = append(stack, token)
+	// Задачи выражения ещё создаются: createTasksForTree их не зарегистрирует
+	if _, ok := planning[expressionID]; ok {
+		planning[expressionID] = true
+	}
 
-		case "(":
-			stack = append(stack, token)
+	queue.removeExpression(expressionID)
 
-		case ")":
-			for len(stack) > 0 && stack[len(stack)-1] != "(" {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			if len(stack) > 0 && stack[len(stack)-1] == "(" {
-				stack = stack[:len(stack)-1]
+	for id, task := range Tasks {
+		if task.ExpressionID == expressionID {
+			// Агенту, который считает задачу, сообщаем, что результат больше не нужен
+			if task.Status == models.TaskRunning {
+				dropLease(task.AgentID, id)
 			}
-
-		default:
-			// Число
-			output = append(output, token)
+			delete(Tasks, id)
+			delete(dependents, id)
 		}
 	}
-
-	// Оставшиеся операторы в стек
-	for len(stack) > 0 {
-		output = append(output, stack[len(stack)-1])
-		stack = stack[:len(stack)-1]
+	if models.MaxRunningPerUser > 0 {
+		notifyTaskReady()
 	}
 
-	return output
-}
-
-// Дополнительные функции
-func precedence(op string) int {
-	switch op {
-	case "+", "-":
-		return 1
-	case "*", "/":
-		return 2
-	default:
-		return 0
+	if err := database.CancelExpressionTasks(taskDB, expressionID); err != nil {
+		log.Printf("Ошибка отмены задач выражения %s: %v", expressionID, err)
 	}
 }
 
-func isOperator(s string) bool {
-	return s == "+" || s == "-" || s == "*" || s == "/"
-}
-
-func isNumber(s string) bool {
-	_, err := strconv.ParseFloat(s, 64)
-	return err == nil
-}
-
-
+// Записываем результат задачи и ставим в очередь зависимые задачи,
+// у которых выполнены все зависимости. Если зависимые задачи нашлись в кэше
+// вплоть до финальной, она возвращается вторым значением
+func completeTask(result models.Responce2) (*models.Task, *models.Task, bool) {
+	TaskMutex.Lock()
+	defer TaskMutex.Unlock()
 
+	task, ok := Tasks[result.Id]
+	if !ok || (task.Status != models.TaskRunning && task.Status != models.TaskQueued) {
+		return nil, nil, false
+	}
 
-func createExpressionTree(rpn []string) *models.ASTNode {
-	var stack []*models.ASTNode
+	// Задача могла вернуться в очередь после истечения аренды, а результат
+	// всё равно пришёл от агента, который её выполнял
+	if task.Status == models.TaskQueued {
+		queue.remove(task)
+	}
 
-	for _, token := range rpn {
-		switch token {
-		case "+", "-", "*", "/":
-			if len(stack) < 2 {
-				panic("некорректное выражение")
-			}
+	task.Status = models.TaskDone
+	task.LeaseDeadline = time.Time{}
+	task.FinishedAt = time.Now()
+	task.Result = result.Result
+	task.DecimalResult = result.ResultText
+	task.ErrorCode = result.ErrorCode
+	task.Error = result.Error
+	if task.ErrorCode != "" {
+		task.Status = models.TaskFailed
+	}
+	countAgentResult(task.AgentID, task.Status == models.TaskFailed)
+	saveTask(task)
 
-			right := stack[len(stack)-1]
-			left := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
+	// У пользователя освободилось место в лимите одновременных задач
+	if models.MaxRunningPerUser > 0 {
+		notifyTaskReady()
+	}
+	if task.Status == models.TaskFailed {
+		return task, nil, true
+	}
 
-			node := &models.ASTNode{
-				Operator: token,
-				Left:     left,
-				Right:    right,
-			}
+	taskCache.put(taskKey(task), cachedResult{Result: task.Result, ResultText: task.DecimalResult})
+	return task, releaseDependents(task), true
+}
 
-			// (необязательная проверка/отладка)
-			_ = getOperatorPriority(token)
+// Передаём результат задачи зависимым задачам, у которых выполнены все
+// зависимости. Возвращает финальную задачу, если она посчитана из кэша
+func releaseDependents(task *models.Task) *models.Task {
+	var finished *models.Task
+	for _, dependentID := range dependents[task.Id] {
+		dependent, ok := Tasks[dependentID]
+		if !ok || dependent.Status != models.TaskWaiting || !dependenciesDone(dependent) {
+			continue
+		}
+		if final := readyTask(dependent); final != nil {
+			finished = final
+		}
+	}
+	delete(dependents, task.Id)
+	return finished
+}
 
-			stack = append(stack, node)
+// Подставляем результаты зависимостей; если такая задача уже считалась,
+// берём результат из кэша, иначе ставим задачу в очередь
+func readyTask(task *models.Task) *models.Task {
+	resolveOperands(task)
+	if applyCachedResult(task) {
+		saveTask(task)
+		if task.IsFinal {
+			return task
+		}
+		return releaseDependents(task)
+	}
 
-		case "u-":
-			if len(stack) < 1 {
-				panic("некорректное выражение")
-			}
+	task.Status = models.TaskQueued
+	saveTask(task)
+	queue.push(task, false)
+	notifyTaskReady()
+	return nil
+}
 
-			operand := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
+// Помечаем задачу выполненной, если её результат есть в кэше
+func applyCachedResult(task *models.Task) bool {
+	cached, ok := taskCache.get(taskKey(task))
+	if !ok {
+		return false
+	}
+	task.Status = models.TaskDone
+	task.FinishedAt = time.Now()
+	task.Result = cached.Result
+	task.DecimalResult = cached.ResultText
+	return true
+}
 
-			node := &models.ASTNode{
-				Operator: "u-",
-				Left:     operand,
-			}
+// Будим агентов, которые ждут задачи. Вызывается под TaskMutex
+func notifyTaskReady() {
+	close(TaskReady)
+	TaskReady = make(chan struct{})
+}
 
-			_ = getOperatorPriority("u-")
+// Сохраняем изменения задачи в хранилище, ошибка записи не останавливает вычисление
+func saveTask(task *models.Task) {
+	if err := database.UpdateTask(taskDB, task); err != nil {
+		log.Printf("Ошибка сохранения задачи %s: %v", task.Id, err)
+	}
+}
 
-			stack = append(stack, node)
+// Записываем итог выражения по его финальной или упавшей задаче
+func finishExpression(db *sql.DB, task *models.Task) error {
+	dropExpressionTasks(task.ExpressionID)
+	if task.Status == models.TaskFailed {
+		storeExpressionResult(task.ExpressionID, nil)
+		return UpdateExpressionError(db, task.ExpressionID, task.ErrorCode, task.Error)
+	}
+	storeExpressionResult(task.ExpressionID, &cachedResult{Result: task.Result, ResultText: task.DecimalResult})
+	return UpdateExpressionResultAndStatus(db, task.ExpressionID, task.Result, task.DecimalResult, models.StatusDone)
+}
 
-		default:
-			var value float64
-			_, err := fmt.Sscanf(token, "%f", &value)
-			if err != nil {
-				panic(fmt.Sprintf("ошибка с: %s", token))
-			}
-			node := &models.ASTNode{
-				Value:  value,
-				IsLeaf: true,
-			}
-			stack = append(stack, node)
+func dependenciesDone(task *models.Task) bool {
+	for _, depID := range task.Dependencies {
+		dep, ok := Tasks[depID]
+		if !ok || dep.Status != models.TaskDone {
+			return false
 		}
 	}
+	return true
+}
 
-	if len(stack) != 1 {
-		panic("что-то пошло не так")
+// Подставляем результаты зависимостей в операнды-ссылки,
+// каждый результат попадает ровно в свой слот
+func resolveOperands(task *models.Task) {
+	for i := range task.Operands {
+		operand := &task.Operands[i]
+		if operand.Ready() {
+			continue
+		}
+		dep := Tasks[operand.Ref]
+		operand.Value = dep.Result
+		operand.Text = dep.DecimalResult
+		operand.Resolved = true
 	}
-
-	return stack[0]
 }
 
-
-
 // Функция для получения приоритета операции
 func getOperatorPriority(operator string) int {
     switch operator {
+    case "^":
+        return 4 // самый высокий приоритет
     case "u-":
-        return 3 // самый высокий приоритет
-    case "*", "/":
+        return 3
+    case "*", "/", "//", "%":
         return 2
     case "+", "-":
         return 1
@@ -371,9 +504,82 @@ func getOperatorPriority(operator string) int {
 }
 
 
-// Функция для рекурсивного обхода дерева и создания задач
-func createTasksForTree(node *models.ASTNode, id string) bool {
+// Точная запись числа-листа для десятичного режима
+func decimalOperand(n *models.ASTNode) string {
+	if n.Literal != "" {
+		return parser.DecimalLiteral(n.Literal)
+	}
+	return strconv.FormatFloat(n.Value, 'f', -1, 64)
+}
+
+// Добавляем узел дерева как очередной операнд задачи: число
+// или ссылку на задачу, которая вычисляет этот узел
+func addOperand(task *models.Task, n *models.ASTNode, precision *models.Precision) {
+	if precision != nil {
+		task.Decimal = precision
+	}
+
+	if !n.IsLeaf {
+		task.Operands = append(task.Operands, models.Operand{Ref: n.TaskID})
+		// Одинаковые поддеревья считаются одной задачей, зависимость записываем один раз
+		if !slices.Contains(task.Dependencies, n.TaskID) {
+			task.Dependencies = append(task.Dependencies, n.TaskID)
+		}
+		return
+	}
+
+	operand := models.Operand{Value: n.Value}
+	if precision != nil {
+		operand.Text = decimalOperand(n)
+	}
+	task.Operands = append(task.Operands, operand)
+}
+
+// Функция для рекурсивного обхода дерева и создания задач.
+// Возвращает false, если дерево - одно число и задачи не нужны.
+// Если результаты всех задач нашлись в кэше, возвращает посчитанную финальную задачу
+func createTasksForTree(node *models.ASTNode, expr *models.Expression) (*models.Task, bool) {
+	id, precision := expr.Id, expr.Precision
 	var finalTaskID string
+	var created []*models.Task
+
+	// Каноническая запись поддерева и задача, которая его считает: одинаковые
+	// поддеревья выражения вычисляются один раз
+	keys := make(map[*models.ASTNode]string)
+	memo := make(map[string]string)
+
+	nodeKey := func(n *models.ASTNode) string {
+		if !n.IsLeaf {
+			return keys[n]
+		}
+		if precision != nil {
+			return decimalOperand(n)
+		}
+		return strconv.FormatFloat(n.Value, 'g', -1, 64)
+	}
+
+	// Если такое же поддерево уже есть, узел ссылается на его задачу
+	reuse := func(n *models.ASTNode, operands ...*models.ASTNode) bool {
+		parts := make([]string, len(operands))
+		for i, operand := range operands {
+			parts[i] = nodeKey(operand)
+		}
+		keys[n] = n.Operator + "(" + strings.Join(parts, ",") + ")"
+
+		taskIDStr, ok := memo[keys[n]]
+		if !ok {
+			return false
+		}
+		n.Value = 0
+		n.IsLeaf = false
+		n.TaskID = taskIDStr
+		n.TaskScheduled = true
+		if n == node {
+			finalTaskID = taskIDStr
+		}
+		memoizedSubtrees.Add(1)
+		return true
+	}
 
 	var traverse func(n *models.ASTNode)
 	traverse = func(n *models.ASTNode) {
@@ -383,44 +589,41 @@ func createTasksForTree(node *models.ASTNode, id string) bool {
 
 		traverse(n.Left)
 		traverse(n.Right)
+		for _, arg := range n.Args {
+			traverse(arg)
+		}
 
 		// === Обработка бинарных операторов ===
 		if n.Left != nil && n.Right != nil {
 			leftReady := n.Left.IsLeaf || n.Left.TaskScheduled
 			rightReady := n.Right.IsLeaf || n.Right.TaskScheduled
 
-			if leftReady && rightReady && !n.TaskScheduled {
+			if leftReady && rightReady && !n.TaskScheduled && !reuse(n, n.Left, n.Right) {
 				TaskMutex.Lock()
 				taskID++
 				taskIDStr := fmt.Sprintf("%d", taskID)
 
 				task := &models.Task{
 					Id:                taskIDStr,
-					Arg1:              n.Left.Value,
-					Arg2:              n.Right.Value,
 					Operation:         n.Operator,
 					Operation_time_ms: float64(getOperationTime(n.Operator)),
 					ExpressionID:      id,
 					IsFinal:           false,
 				}
 
-				if !n.Left.IsLeaf {
-					task.Dependencies = append(task.Dependencies, n.Left.TaskID)
-				}
-				if !n.Right.IsLeaf {
-					task.Dependencies = append(task.Dependencies, n.Right.TaskID)
-				}
+				addOperand(task, n.Left, precision)
+				addOperand(task, n.Right, precision)
 
 				priority := getOperatorPriority(n.Operator)
 				log.Printf("Приоритет оператора %s: %d", n.Operator, priority)
 
-				Tasks[taskIDStr] = task
-				TaskQueue = append(TaskQueue, task)
+				created = append(created, task)
 
 				n.Value = 0
 				n.IsLeaf = false
 				n.TaskID = taskIDStr
 				n.TaskScheduled = true
+				memo[keys[n]] = taskIDStr
 
 				if n == node {
 					finalTaskID = taskIDStr
@@ -433,34 +636,89 @@ func createTasksForTree(node *models.ASTNode, id string) bool {
 		if n.Operator == "u-" && n.Left != nil && n.Right == nil && !n.TaskScheduled {
 			leftReady := n.Left.IsLeaf || n.Left.TaskScheduled
 
-			if leftReady {
+			if leftReady && !reuse(n, n.Left) {
 				TaskMutex.Lock()
 				taskID++
 				taskIDStr := fmt.Sprintf("%d", taskID)
 
 				task := &models.Task{
 					Id:                taskIDStr,
-					Arg1:              n.Left.Value,
 					Operation:         "u-",
 					Operation_time_ms: float64(getOperationTime("u-")),
 					ExpressionID:      id,
 					IsFinal:           false,
 				}
 
-				if !n.Left.IsLeaf {
-					task.Dependencies = append(task.Dependencies, n.Left.TaskID)
-				}
+				addOperand(task, n.Left, precision)
 
 				priority := getOperatorPriority("u-")
 				log.Printf("Приоритет унарного оператора %s: %d", n.Operator, priority)
 
-				Tasks[taskIDStr] = task
-				TaskQueue = append(TaskQueue, task)
+				created = append(created, task)
 
 				n.Value = 0
 				n.IsLeaf = false
 				n.TaskID = taskIDStr
 				n.TaskScheduled = true
+				memo[keys[n]] = taskIDStr
+
+				if n == node {
+					finalTaskID = taskIDStr
+				}
+				TaskMutex.Unlock()
+			}
+		}
+
+		// === Унарный плюс не меняет значение, узел наследует операнд ===
+		if n.Operator == "u+" && n.Left != nil && !n.TaskScheduled && !n.IsLeaf {
+			if n.Left.IsLeaf {
+				n.Value = n.Left.Value
+				n.Literal = n.Left.Literal
+				n.IsLeaf = true
+			} else if n.Left.TaskScheduled {
+				n.TaskID = n.Left.TaskID
+				n.TaskScheduled = true
+				keys[n] = keys[n.Left]
+
+				if n == node {
+					finalTaskID = n.TaskID
+				}
+			}
+		}
+
+		// === Обработка вызовов функций ===
+		if len(n.Args) > 0 && !n.TaskScheduled {
+			argsReady := true
+			for _, arg := range n.Args {
+				if !arg.IsLeaf && !arg.TaskScheduled {
+					argsReady = false
+				}
+			}
+
+			if argsReady && !reuse(n, n.Args...) {
+				TaskMutex.Lock()
+				taskID++
+				taskIDStr := fmt.Sprintf("%d", taskID)
+
+				task := &models.Task{
+					Id:                taskIDStr,
+					Operation:         n.Operator,
+					Operation_time_ms: float64(getOperationTime(n.Operator)),
+					ExpressionID:      id,
+					IsFinal:           false,
+				}
+
+				for _, arg := range n.Args {
+					addOperand(task, arg, precision)
+				}
+
+				created = append(created, task)
+
+				n.Value = 0
+				n.IsLeaf = false
+				n.TaskID = taskIDStr
+				n.TaskScheduled = true
+				memo[keys[n]] = taskIDStr
 
 				if n == node {
 					finalTaskID = taskIDStr
@@ -472,29 +730,92 @@ func createTasksForTree(node *models.ASTNode, id string) bool {
 
 	traverse(node)
 
-	if finalTaskID != "" {
-		TaskMutex.Lock()
-		if finalTask, ok := Tasks[finalTaskID]; ok {
-			finalTask.IsFinal = true
+	if finalTaskID == "" {
+		return nil, false
+	}
+
+	// Регистрируем задачи разом, чтобы агент не получил финальную задачу
+	// раньше, чем она будет помечена; в очередь сразу идут задачи без зависимостей
+	TaskMutex.Lock()
+	defer TaskMutex.Unlock()
+
+	// Выражение прервали, пока строилось дерево задач
+	if planning[id] {
+		return nil, true
+	}
+
+	now := time.Now()
+	for _, task := range created {
+		task.IsFinal = task.Id == finalTaskID
+		setExpressionFields(task, expr)
+		task.Status = models.TaskWaiting
+		task.CreatedAt = now
+		Tasks[task.Id] = task
+	}
+
+	// Задачи созданы в порядке обхода, поэтому зависимости проверены раньше
+	// зависимых: задачи, результат которых есть в кэше, сразу выполнены
+	var finished *models.Task
+	for _, task := range created {
+		if !dependenciesDone(task) {
+			continue
+		}
+		resolveOperands(task)
+		if !applyCachedResult(task) {
+			task.Status = models.TaskQueued
+		} else if task.IsFinal {
+			finished = task
 		}
-		TaskMutex.Unlock()
 	}
 
-	return true
+	expr.MinMakespanMs = assignCriticalPaths(created)
+	if err := database.SaveTasks(taskDB, created); err != nil {
+		log.Printf("Ошибка сохранения задач выражения %s: %v", id, err)
+	}
+
+	for _, task := range created {
+		if task.Status == models.TaskQueued {
+			queue.push(task, false)
+			continue
+		}
+		for _, depID := range task.Dependencies {
+			if Tasks[depID].Status != models.TaskDone {
+				dependents[depID] = append(dependents[depID], task.Id)
+			}
+		}
+	}
+	notifyTaskReady()
+
+	return finished, true
 }
 
 
+// Переносим в задачу поля выражения, по которым очередь выбирает задачи
+func setExpressionFields(task *models.Task, expr *models.Expression) {
+	task.UserID = expr.UserID
+	task.Priority = expr.Priority
+	if expr.Deadline != nil {
+		task.Deadline = *expr.Deadline
+	}
+}
+
 func getOperationTime(operator string) int {
     switch operator {
     case "+":
         return models.Tadd
-    case "-":
+    case "-", "u-":
         return models.Tsub
     case "*":
         return models.Tmul
     case "/":
         return models.Tdiv
+    case "^":
+        return models.Tpow
+    case "%":
+        return models.Tmod
+    case "//":
+        return models.Tidiv
     default:
-        return 0
+        return models.FunctionTimes[operator]
     }
 }
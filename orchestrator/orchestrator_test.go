@@ -0,0 +1,304 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"calc/parser"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func leafNode(value float64) *models.ASTNode {
+	return &models.ASTNode{Value: value, IsLeaf: true}
+}
+
+// Узел, для которого уже создана задача taskID
+func taskNode(taskID string) *models.ASTNode {
+	return &models.ASTNode{TaskID: taskID, TaskScheduled: true}
+}
+
+func TestOperandOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodes     []*models.ASTNode
+		precision *models.Precision
+		// Результаты задач-зависимостей
+		results map[string]float64
+		// Операнды, значения которых уже подставлены до вызова resolveOperands
+		preResolved map[int]float64
+		wantDeps    []string
+		want        []models.Operand
+	}{
+		{
+			name:     "литерал 0 слева",
+			nodes:    []*models.ASTNode{leafNode(0), taskNode("1")},
+			results:  map[string]float64{"1": 5},
+			wantDeps: []string{"1"},
+			want: []models.Operand{
+				{Value: 0},
+				{Value: 5, Ref: "1", Resolved: true},
+			},
+		},
+		{
+			name:      "литерал 0 слева в десятичном режиме",
+			nodes:     []*models.ASTNode{leafNode(0), taskNode("1")},
+			precision: &models.Precision{Scale: 2},
+			results:   map[string]float64{"1": 5},
+			wantDeps:  []string{"1"},
+			want: []models.Operand{
+				{Value: 0, Text: "0"},
+				{Value: 5, Text: "5", Ref: "1", Resolved: true},
+			},
+		},
+		{
+			name:     "правая зависимость создана раньше левой",
+			nodes:    []*models.ASTNode{taskNode("2"), taskNode("1")},
+			results:  map[string]float64{"1": 10, "2": 3},
+			wantDeps: []string{"2", "1"},
+			want: []models.Operand{
+				{Value: 3, Ref: "2", Resolved: true},
+				{Value: 10, Ref: "1", Resolved: true},
+			},
+		},
+		{
+			name:        "правый операнд подставлен раньше левого",
+			nodes:       []*models.ASTNode{taskNode("1"), taskNode("2")},
+			results:     map[string]float64{"1": 7, "2": 100},
+			preResolved: map[int]float64{1: 4},
+			wantDeps:    []string{"1", "2"},
+			want: []models.Operand{
+				{Value: 7, Ref: "1", Resolved: true},
+				{Value: 4, Ref: "2", Resolved: true},
+			},
+		},
+		{
+			name:     "оба операнда - одна мемоизированная задача",
+			nodes:    []*models.ASTNode{taskNode("1"), taskNode("1")},
+			results:  map[string]float64{"1": 3},
+			wantDeps: []string{"1"},
+			want: []models.Operand{
+				{Value: 3, Ref: "1", Resolved: true},
+				{Value: 3, Ref: "1", Resolved: true},
+			},
+		},
+		{
+			name:     "аргументы функции: литералы и ссылки вперемешку",
+			nodes:    []*models.ASTNode{leafNode(1), taskNode("1"), leafNode(0), taskNode("2")},
+			results:  map[string]float64{"1": -2, "2": 8},
+			wantDeps: []string{"1", "2"},
+			want: []models.Operand{
+				{Value: 1},
+				{Value: -2, Ref: "1", Resolved: true},
+				{Value: 0},
+				{Value: 8, Ref: "2", Resolved: true},
+			},
+		},
+	}
+
+	saved := Tasks
+	defer func() { Tasks = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &models.Task{Id: "3"}
+			for _, node := range tt.nodes {
+				addOperand(task, node, tt.precision)
+			}
+			if !reflect.DeepEqual(task.Dependencies, tt.wantDeps) {
+				t.Errorf("зависимости %v, ожидалось %v", task.Dependencies, tt.wantDeps)
+			}
+
+			for i, value := range tt.preResolved {
+				task.Operands[i].Value = value
+				task.Operands[i].Resolved = true
+			}
+
+			Tasks = make(map[string]*models.Task)
+			for id, result := range tt.results {
+				dep := &models.Task{Id: id, Status: models.TaskDone, Result: result}
+				if tt.precision != nil {
+					dep.DecimalResult = formatTestValue(result)
+				}
+				Tasks[id] = dep
+			}
+
+			resolveOperands(task)
+			if !reflect.DeepEqual(task.Operands, tt.want) {
+				t.Errorf("операнды %+v, ожидалось %+v", task.Operands, tt.want)
+			}
+			for i, operand := range task.Operands {
+				if !operand.Ready() {
+					t.Errorf("операнд %d не подставлен", i+1)
+				}
+			}
+		})
+	}
+}
+
+func formatTestValue(value float64) string {
+	return decimalOperand(leafNode(value))
+}
+
+// Значение дерева во float64 в том порядке, в котором его посчитают агенты
+func evalTestTree(t *testing.T, node *models.ASTNode) float64 {
+	t.Helper()
+	if node.IsLeaf {
+		return node.Value
+	}
+	var args []float64
+	for _, operand := range append([]*models.ASTNode{node.Left, node.Right}, node.Args...) {
+		if operand != nil {
+			args = append(args, evalTestTree(t, operand))
+		}
+	}
+	if node.Operator == "sqrt" {
+		return math.Sqrt(args[0])
+	}
+	value, ok := evaluate(node.Operator, args)
+	if !ok {
+		t.Fatalf("операция %s не посчитана", node.Operator)
+	}
+	return value
+}
+
+func TestOptimizeAndRebalance(t *testing.T) {
+	sum := "1"
+	for i := 2; i <= 100; i++ {
+		sum += "+" + strconv.Itoa(i)
+	}
+
+	tests := []struct {
+		name        string
+		expression  string
+		variables   map[string]float64
+		foldMaxCost int
+		rebalance   bool
+		wantDepth   int
+		wantResult  float64
+	}{
+		{"цепочка из 100 слагаемых без свёртки", sum, nil, 0, true, 7, 5050},
+		{"цепочка из 100 слагаемых со свёрткой", sum, nil, 1000, true, 0, 5050},
+		{"перестановка чисел при rebalance", "1e16 + sqrt(x) + 1", map[string]float64{"x": 4}, 1000, true, 2, 1.0000000000000002e16},
+		{"записанный порядок без rebalance", "1e16 + sqrt(x) + 1", map[string]float64{"x": 4}, 1000, false, 3, 1.0000000000000004e16},
+		{"без rebalance сворачиваются числа в начале", "1 + 2 + sqrt(x) + 3", map[string]float64{"x": 4}, 1000, false, 3, 8},
+		{"без rebalance скобки сохраняются", "x+(y+z)", map[string]float64{"x": 1, "y": 1e16, "z": -1e16}, 0, false, 2, 1},
+		{"скобки без rebalance и со свёрткой", "x+(y+z)", map[string]float64{"x": 1, "y": 1e16, "z": -1e16}, 1000, false, 0, 1},
+	}
+
+	saved := models.FoldMaxCost
+	defer func() { models.FoldMaxCost = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models.FoldMaxCost = tt.foldMaxCost
+
+			tree, err := parser.Parse(tt.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := parser.Bind(tree, tt.variables); err != nil {
+				t.Fatal(err)
+			}
+
+			tree = optimizeTree(tree, nil, tt.rebalance)
+			if tt.rebalance {
+				tree = rebalanceTree(tree, nil)
+			}
+			if depth := treeDepth(tree); depth != tt.wantDepth {
+				t.Errorf("глубина %d, ожидалось %d", depth, tt.wantDepth)
+			}
+			if result := evalTestTree(t, tree); result != tt.wantResult {
+				t.Errorf("результат %v, ожидалось %v", result, tt.wantResult)
+			}
+		})
+	}
+}
+
+// Дерево со всеми скобками: (x+(y+z))
+func formatTestTree(node *models.ASTNode) string {
+	if node.IsLeaf {
+		if node.Variable != "" {
+			return node.Variable
+		}
+		return strconv.FormatFloat(node.Value, 'g', -1, 64)
+	}
+	if node.Right != nil {
+		return "(" + formatTestTree(node.Left) + node.Operator + formatTestTree(node.Right) + ")"
+	}
+	operands := []*models.ASTNode{node.Left}
+	if node.Left == nil {
+		operands = node.Args
+	}
+	args := make([]string, len(operands))
+	for i, operand := range operands {
+		args[i] = formatTestTree(operand)
+	}
+	return node.Operator + "(" + strings.Join(args, ",") + ")"
+}
+
+// Цепочка перестраивается, только если из неё что-то убрано или свёрнуто
+func TestOptimizeChainGrouping(t *testing.T) {
+	tests := []struct {
+		expression  string
+		foldMaxCost int
+		want        string
+	}{
+		{"x+(y+z)", 0, "(x+(y+z))"},
+		{"(a+b)*(c*d)", 0, "((a+b)*(c*d))"},
+		{"x+(sqrt(y)+z*1)", 0, "(x+(sqrt(y)+z))"},
+		{"sqrt(x)+(2+sqrt(y))", 1000, "(sqrt(x)+(2+sqrt(y)))"},
+		{"x+(y+0)", 0, "(x+y)"},
+		{"x*(y*1)*z", 0, "((x*y)*z)"},
+		{"sqrt(x)+(1+sqrt(y))+2", 1000, "((3+sqrt(x))+sqrt(y))"},
+		{"x+(y+z)*1", 0, "((x+y)+z)"},
+	}
+
+	saved := models.FoldMaxCost
+	defer func() { models.FoldMaxCost = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			models.FoldMaxCost = tt.foldMaxCost
+
+			tree, err := parser.Parse(tt.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			variables := map[string]float64{"a": 5, "b": 5, "c": 5, "d": 5, "x": 5, "y": 5, "z": 5}
+			if err := parser.Bind(tree, variables); err != nil {
+				t.Fatal(err)
+			}
+			if got := formatTestTree(optimizeTree(tree, nil, true)); got != tt.want {
+				t.Errorf("%s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Выражение прервали, пока для него строились задачи: задачи не регистрируются
+func TestAbortWhilePlanning(t *testing.T) {
+	savedTasks, savedPlanning := Tasks, planning
+	defer func() { Tasks, planning = savedTasks, savedPlanning }()
+	Tasks = make(map[string]*models.Task)
+	planning = map[string]bool{"e1": true}
+
+	tree, err := parser.Parse("(1+2)*sqrt(9)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished, ok := createTasksForTree(tree, &models.Expression{Id: "e1"})
+	if finished != nil || !ok {
+		t.Errorf("createTasksForTree = %v, %v", finished, ok)
+	}
+	if len(Tasks) != 0 || queue.len() != 0 {
+		t.Errorf("зарегистрировано задач: %d, в очереди: %d", len(Tasks), queue.len())
+	}
+	if !endPlanning("e1") {
+		t.Error("endPlanning не сообщил о прерывании")
+	}
+	if _, ok := planning["e1"]; ok {
+		t.Error("выражение осталось в planning")
+	}
+}
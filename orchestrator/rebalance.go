@@ -0,0 +1,74 @@
+package orchestrator
+
+import "calc/models"
+
+// Перестраиваем цепочки + и * в сбалансированные деревья: 1+2+...+100
+// парсер строит цепочкой, где каждая задача ждёт предыдущую, а после
+// перестройки глубина дерева - log2 от числа слагаемых и задачи цепочки
+// считаются параллельно.
+//
+// Во float64 сложение и умножение не ассоциативны, поэтому результат может
+// отличаться в последних знаках; в десятичном режиме результат каждой задачи
+// округляется, поэтому там дерево не перестраивается
+func rebalanceTree(node *models.ASTNode, precision *models.Precision) *models.ASTNode {
+	if node == nil || node.IsLeaf || precision != nil {
+		return node
+	}
+
+	if (node.Operator == "+" || node.Operator == "*") && node.Right != nil {
+		var operands []*models.ASTNode
+		var collect func(n *models.ASTNode)
+		collect = func(n *models.ASTNode) {
+			if n.Operator == node.Operator && !n.IsLeaf && n.Right != nil {
+				collect(n.Left)
+				collect(n.Right)
+				return
+			}
+			operands = append(operands, rebalanceTree(n, precision))
+		}
+		collect(node)
+		return balance(node, operands)
+	}
+
+	if node.Left != nil {
+		node.Left = rebalanceTree(node.Left, precision)
+	}
+	if node.Right != nil {
+		node.Right = rebalanceTree(node.Right, precision)
+	}
+	for i, arg := range node.Args {
+		node.Args[i] = rebalanceTree(arg, precision)
+	}
+	return node
+}
+
+// Собираем операнды цепочки в дерево минимальной глубины
+func balance(chain *models.ASTNode, operands []*models.ASTNode) *models.ASTNode {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	mid := len(operands) / 2
+	return &models.ASTNode{
+		Operator: chain.Operator,
+		Left:     balance(chain, operands[:mid]),
+		Right:    balance(chain, operands[mid:]),
+		Column:   chain.Column,
+	}
+}
+
+// Глубина дерева - самая длинная цепочка операций, которые выполняются
+// друг за другом. Число имеет глубину 0, унарный плюс задачей не считается
+func treeDepth(node *models.ASTNode) int {
+	if node == nil || node.IsLeaf {
+		return 0
+	}
+
+	depth := max(treeDepth(node.Left), treeDepth(node.Right))
+	for _, arg := range node.Args {
+		depth = max(depth, treeDepth(arg))
+	}
+	if node.Operator == "u+" {
+		return depth
+	}
+	return depth + 1
+}
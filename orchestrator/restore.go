@@ -0,0 +1,137 @@
+package orchestrator
+
+import (
+	"calc/database"
+	"calc/models"
+	"calc/parser"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Подключаем хранилище задач и восстанавливаем очередь выражений,
+// которые не успели досчитаться до перезапуска оркестратора
+func InitTaskStore(db *sql.DB, expressionDB *sql.DB) error {
+	taskDB = db
+
+	maxID, err := database.MaxTaskID(db)
+	if err != nil {
+		return err
+	}
+	TaskMutex.Lock()
+	taskID = maxID
+	TaskMutex.Unlock()
+
+	expressions, err := database.GetUnfinishedExpressions(expressionDB)
+	if err != nil {
+		return err
+	}
+	if len(expressions) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(expressions))
+	for i, expr := range expressions {
+		ids[i] = expr.Id
+	}
+	tasks, err := database.LoadTasks(db, ids)
+	if err != nil {
+		return err
+	}
+
+	// Задачи ставим в очередь в порядке создания
+	sortTasksByID(tasks)
+
+	byExpression := make(map[string][]*models.Task)
+	for _, task := range tasks {
+		byExpression[task.ExpressionID] = append(byExpression[task.ExpressionID], task)
+	}
+
+	for i := range expressions {
+		expr := &expressions[i]
+		exprTasks := byExpression[expr.Id]
+		for _, task := range exprTasks {
+			setExpressionFields(task, expr)
+		}
+		assignCriticalPaths(exprTasks)
+
+		if len(exprTasks) == 0 {
+			// Задачи ещё не были созданы, разбираем выражение заново
+			restartExpression(expressionDB, expr)
+		} else if finished := restoreTasks(exprTasks); finished != nil {
+			if err := finishExpression(expressionDB, finished); err != nil {
+				log.Printf("Ошибка записи результата выражения %s: %v", expr.Id, err)
+			}
+			continue
+		}
+
+		// Истёкший за время простоя срок сработает сразу
+		scheduleDeadline(expressionDB, expr)
+	}
+
+	log.Printf("Восстановлено выражений: %d, задач в очереди: %d", len(expressions), queue.len())
+	return nil
+}
+
+// Возвращаем задачи выражения в граф и очередь. Если финальная задача уже
+// выполнена или какая-то задача упала, возвращаем её, чтобы записать итог
+func restoreTasks(tasks []*models.Task) *models.Task {
+	TaskMutex.Lock()
+	defer TaskMutex.Unlock()
+
+	for _, task := range tasks {
+		if task.Status == models.TaskFailed || (task.IsFinal && task.Status == models.TaskDone) {
+			return task
+		}
+	}
+
+	for _, task := range tasks {
+		Tasks[task.Id] = task
+	}
+	var finished *models.Task
+	for _, task := range tasks {
+		switch task.Status {
+		case models.TaskRunning:
+			// Аренда продолжает действовать: агент может прислать результат,
+			// иначе по истечении срока задача вернётся в очередь
+			if task.LeaseDeadline.IsZero() {
+				task.LeaseDeadline = time.Now().Add(leaseDuration())
+				saveTask(task)
+			}
+		case models.TaskQueued:
+			queue.push(task, false)
+		case models.TaskWaiting:
+			if dependenciesDone(task) {
+				if final := readyTask(task); final != nil {
+					finished = final
+				}
+				continue
+			}
+			for _, depID := range task.Dependencies {
+				if dep, ok := Tasks[depID]; !ok || dep.Status != models.TaskDone {
+					dependents[depID] = append(dependents[depID], task.Id)
+				}
+			}
+		}
+	}
+	return finished
+}
+
+// Заново разбираем выражение, для которого не успели создать задачи
+func restartExpression(db *sql.DB, expr *models.Expression) {
+	parse := parser.Parse
+	if expr.Precision != nil {
+		parse = parser.ParseDecimal
+	}
+	tree, err := parse(expr.Expression)
+	if err == nil {
+		err = parser.Bind(tree, expr.Variables)
+	}
+	if err != nil {
+		if err := UpdateExpressionError(db, expr.Id, models.ErrInvalidArgument, err.Error()); err != nil {
+			log.Printf("Ошибка записи статуса выражения %s: %v", expr.Id, err)
+		}
+		return
+	}
+	go ProcessExpression(db, expr, tree)
+}
@@ -0,0 +1,263 @@
+package orchestrator
+
+import (
+	"calc/models"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+)
+
+// Очередь готовых задач с честным распределением: задачи выдаются
+// по кругу между пользователями, а внутри пользователя - по кругу между
+// его выражениями, поэтому большое выражение одного пользователя
+// не задерживает остальных. Срочные выражения (приоритет, срок)
+// обслуживаются первыми. Все методы вызываются под TaskMutex
+type scheduler struct {
+	users map[string]*userQueue
+	ring  []string // пользователи, у которых есть задачи в очереди
+	next  int      // с кого начинать следующий обход
+}
+
+// Задачи одного пользователя, сгруппированные по выражениям
+type userQueue struct {
+	expressions map[string][]*models.Task
+	ring        []string
+	next        int
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{users: make(map[string]*userQueue)}
+}
+
+// Ставим задачу в конец очереди её выражения, front - в начало
+func (s *scheduler) push(task *models.Task, front bool) {
+	uq, ok := s.users[task.UserID]
+	if !ok {
+		uq = &userQueue{expressions: make(map[string][]*models.Task)}
+		s.users[task.UserID] = uq
+		s.ring = append(s.ring, task.UserID)
+	}
+
+	tasks, ok := uq.expressions[task.ExpressionID]
+	if !ok {
+		uq.ring = append(uq.ring, task.ExpressionID)
+	}
+	if front {
+		tasks = append([]*models.Task{task}, tasks...)
+	} else {
+		tasks = append(tasks, task)
+	}
+	uq.expressions[task.ExpressionID] = tasks
+}
+
+// Берём следующую задачу, которую принимает accept. Пользователи, у которых
+// уже running[user] задач в работе, пропускаются при достижении лимита.
+// Сначала выдаются самые срочные задачи (см. moreUrgent), а среди
+// одинаково срочных - по кругу между пользователями и выражениями
+func (s *scheduler) take(accept func(*models.Task) bool, running map[string]int) *models.Task {
+	capped := func(userID string) bool {
+		return models.MaxRunningPerUser > 0 && running[userID] >= models.MaxRunningPerUser
+	}
+
+	var urgent *models.Task
+	for userID, uq := range s.users {
+		if capped(userID) {
+			continue
+		}
+		for _, tasks := range uq.expressions {
+			for _, task := range tasks {
+				if accept(task) && (urgent == nil || moreUrgent(task, urgent)) {
+					urgent = task
+				}
+			}
+		}
+	}
+	if urgent == nil {
+		return nil
+	}
+	asUrgent := func(task *models.Task) bool {
+		return accept(task) && !moreUrgent(urgent, task)
+	}
+
+	for i := range s.ring {
+		index := (s.next + i) % len(s.ring)
+		userID := s.ring[index]
+		if capped(userID) {
+			continue
+		}
+
+		uq := s.users[userID]
+		task := uq.take(asUrgent)
+		if task == nil {
+			continue
+		}
+
+		s.next = index + 1
+		if len(uq.ring) == 0 {
+			s.removeUser(index)
+		}
+		return task
+	}
+	return nil
+}
+
+func (uq *userQueue) take(accept func(*models.Task) bool) *models.Task {
+	for i := range uq.ring {
+		index := (uq.next + i) % len(uq.ring)
+		expressionID := uq.ring[index]
+		tasks := uq.expressions[expressionID]
+
+		// Из задач выражения первой выдаём задачу с самым длинным критическим путём
+		pos := -1
+		for i, task := range tasks {
+			if accept(task) && (pos < 0 || task.CriticalPathMs > tasks[pos].CriticalPathMs) {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			continue
+		}
+		task := tasks[pos]
+		uq.expressions[expressionID] = slices.Delete(tasks, pos, pos+1)
+
+		uq.next = index + 1
+		if len(uq.expressions[expressionID]) == 0 {
+			uq.removeExpression(index)
+		}
+		return task
+	}
+	return nil
+}
+
+// Убираем задачу из очереди, если она там есть
+func (s *scheduler) remove(task *models.Task) {
+	uq, ok := s.users[task.UserID]
+	if !ok {
+		return
+	}
+	tasks := uq.expressions[task.ExpressionID]
+	pos := slices.Index(tasks, task)
+	if pos < 0 {
+		return
+	}
+	uq.expressions[task.ExpressionID] = slices.Delete(tasks, pos, pos+1)
+	if len(uq.expressions[task.ExpressionID]) == 0 {
+		uq.removeExpression(slices.Index(uq.ring, task.ExpressionID))
+	}
+	if len(uq.ring) == 0 {
+		s.removeUser(slices.Index(s.ring, task.UserID))
+	}
+}
+
+// Убираем из очереди все задачи выражения
+func (s *scheduler) removeExpression(expressionID string) {
+	for userID, uq := range s.users {
+		index := slices.Index(uq.ring, expressionID)
+		if index < 0 {
+			continue
+		}
+		uq.removeExpression(index)
+		if len(uq.ring) == 0 {
+			s.removeUser(slices.Index(s.ring, userID))
+		}
+		return
+	}
+}
+
+func (s *scheduler) removeUser(index int) {
+	delete(s.users, s.ring[index])
+	s.ring = slices.Delete(s.ring, index, index+1)
+	if index < s.next {
+		s.next--
+	}
+	if s.next >= len(s.ring) {
+		s.next = 0
+	}
+}
+
+func (uq *userQueue) removeExpression(index int) {
+	delete(uq.expressions, uq.ring[index])
+	uq.ring = slices.Delete(uq.ring, index, index+1)
+	if index < uq.next {
+		uq.next--
+	}
+	if uq.next >= len(uq.ring) {
+		uq.next = 0
+	}
+}
+
+// Общее число задач в очереди
+func (s *scheduler) len() int {
+	n := 0
+	for _, uq := range s.users {
+		for _, tasks := range uq.expressions {
+			n += len(tasks)
+		}
+	}
+	return n
+}
+
+// Число выданных агентам задач по пользователям. Вызывается под TaskMutex
+func runningByUser() map[string]int {
+	running := make(map[string]int)
+	for _, task := range Tasks {
+		if task.Status == models.TaskRunning {
+			running[task.UserID]++
+		}
+	}
+	return running
+}
+
+// Глубина очереди одного пользователя для /admin/queues
+type userQueueDepth struct {
+	UserID      string         `json:"user_id"`
+	Queued      int            `json:"queued"`
+	Running     int            `json:"running"`
+	Expressions map[string]int `json:"expressions"` // ID выражения -> задач в очереди
+}
+
+// Глубина очереди по пользователям: сколько задач ждёт выдачи
+// и сколько уже считают агенты
+func ListQueuesHandler(w http.ResponseWriter, r *http.Request) {
+	var response struct {
+		Queued            int              `json:"queued"`
+		MaxRunningPerUser int              `json:"max_running_per_user"` // 0 - без ограничения
+		Users             []userQueueDepth `json:"users"`
+	}
+	response.MaxRunningPerUser = models.MaxRunningPerUser
+
+	TaskMutex.Lock()
+	depths := make(map[string]*userQueueDepth)
+	depth := func(userID string) *userQueueDepth {
+		d, ok := depths[userID]
+		if !ok {
+			d = &userQueueDepth{UserID: userID, Expressions: make(map[string]int)}
+			depths[userID] = d
+		}
+		return d
+	}
+	for userID, uq := range queue.users {
+		d := depth(userID)
+		for expressionID, tasks := range uq.expressions {
+			d.Queued += len(tasks)
+			d.Expressions[expressionID] = len(tasks)
+		}
+		response.Queued += d.Queued
+	}
+	for userID, n := range runningByUser() {
+		depth(userID).Running = n
+	}
+	TaskMutex.Unlock()
+
+	response.Users = make([]userQueueDepth, 0, len(depths))
+	for _, d := range depths {
+		response.Users = append(response.Users, *d)
+	}
+	sort.Slice(response.Users, func(i, j int) bool {
+		return response.Users[i].UserID < response.Users[j].UserID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"calc/models"
+	"fmt"
+)
+
+// Bind подставляет значения переменных в листья дерева.
+// Если какая-то переменная не задана, возвращается ошибка с её позицией.
+func Bind(node *models.ASTNode, variables map[string]float64) error {
+	if node == nil {
+		return nil
+	}
+
+	if node.Variable != "" {
+		value, ok := variables[node.Variable]
+		if !ok {
+			return &SyntaxError{
+				Column:  node.Column,
+				Token:   node.Variable,
+				Message: fmt.Sprintf("не задано значение переменной %q", node.Variable),
+			}
+		}
+		node.Value = value
+		return nil
+	}
+
+	if err := Bind(node.Left, variables); err != nil {
+		return err
+	}
+	if err := Bind(node.Right, variables); err != nil {
+		return err
+	}
+	for _, arg := range node.Args {
+		if err := Bind(arg, variables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Типы токенов
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenNumber
+	TokenPlus
+	TokenMinus
+	TokenStar
+	TokenSlash
+	TokenLParen
+	TokenRParen
+	TokenCaret
+	TokenPercent
+	TokenDoubleSlash
+	TokenIdent
+	TokenComma
+)
+
+// Человекочитаемые названия токенов, используются в сообщениях об ошибках
+var tokenNames = map[TokenKind]string{
+	TokenEOF:         "конец выражения",
+	TokenNumber:      "число",
+	TokenPlus:        "+",
+	TokenMinus:       "-",
+	TokenStar:        "*",
+	TokenSlash:       "/",
+	TokenLParen:      "(",
+	TokenRParen:      ")",
+	TokenCaret:       "^",
+	TokenPercent:     "%",
+	TokenDoubleSlash: "//",
+	TokenIdent:       "идентификатор",
+	TokenComma:       ",",
+}
+
+func (k TokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("токен(%d)", int(k))
+}
+
+type Token struct {
+	Kind   TokenKind
+	Text   string
+	Column int     // позиция первого символа токена, считая с 1
+	Value  float64 // значение числового литерала
+}
+
+// Lexer разбивает строку на токены
+type Lexer struct {
+	input []rune
+	pos   int
+	exact bool // десятичный режим: числа вне диапазона float64 допустимы
+}
+
+func NewLexer(input string) *Lexer {
+	return &Lexer{input: []rune(input)}
+}
+
+// Символ на offset позиций дальше текущего или 0 за концом строки
+func (l *Lexer) peek(offset int) rune {
+	if l.pos+offset < len(l.input) {
+		return l.input[l.pos+offset]
+	}
+	return 0
+}
+
+// Next возвращает следующий токен или ошибку с позицией
+func (l *Lexer) Next() (Token, error) {
+	// Пропускаем пробелы
+	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
+		l.pos++
+	}
+
+	if l.pos >= len(l.input) {
+		return Token{Kind: TokenEOF, Column: l.pos + 1}, nil
+	}
+
+	start := l.pos
+	ch := l.input[l.pos]
+
+	if unicode.IsDigit(ch) || (ch == '.' && isDecimalDigit(l.peek(1))) {
+		return l.readNumber()
+	}
+	if isIdentStart(ch) {
+		return l.readIdent(), nil
+	}
+
+	var kind TokenKind
+	switch ch {
+	case '+':
+		kind = TokenPlus
+	case '-':
+		kind = TokenMinus
+	case '*':
+		kind = TokenStar
+	case '/':
+		// "//" - целочисленное деление
+		if l.pos+1 < len(l.input) && l.input[l.pos+1] == '/' {
+			l.pos += 2
+			return Token{Kind: TokenDoubleSlash, Text: "//", Column: start + 1}, nil
+		}
+		kind = TokenSlash
+	case '^':
+		kind = TokenCaret
+	case '%':
+		kind = TokenPercent
+	case ',':
+		kind = TokenComma
+	case '(':
+		kind = TokenLParen
+	case ')':
+		kind = TokenRParen
+	default:
+		return Token{}, &SyntaxError{
+			Column:  start + 1,
+			Token:   string(ch),
+			Message: fmt.Sprintf("недопустимый символ %q", ch),
+		}
+	}
+
+	l.pos++
+	return Token{Kind: kind, Text: string(ch), Column: start + 1}, nil
+}
+
+func isIdentStart(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
+}
+
+// Читаем идентификатор: буквы, цифры и подчёркивания
+func (l *Lexer) readIdent() Token {
+	start := l.pos
+	for l.pos < len(l.input) && (isIdentStart(l.input[l.pos]) || unicode.IsDigit(l.input[l.pos])) {
+		l.pos++
+	}
+	return Token{Kind: TokenIdent, Text: string(l.input[start:l.pos]), Column: start + 1}
+}
@@ -0,0 +1,206 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func isDecimalDigit(ch rune) bool { return ch >= '0' && ch <= '9' }
+func isBinaryDigit(ch rune) bool  { return ch == '0' || ch == '1' }
+func isHexDigit(ch rune) bool {
+	return isDecimalDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+// Читаем числовой литерал: 12, 12.5, .5, 1e-9, 1_000_000, 0xFF, 0b1010
+func (l *Lexer) readNumber() (Token, error) {
+	start := l.pos
+
+	var err error
+	switch {
+	case l.input[l.pos] == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X'):
+		err = l.readPrefixed(start, isHexDigit, "шестнадцатеричн")
+	case l.input[l.pos] == '0' && (l.peek(1) == 'b' || l.peek(1) == 'B'):
+		err = l.readPrefixed(start, isBinaryDigit, "двоичн")
+	default:
+		err = l.readDecimal(start)
+	}
+	if err != nil {
+		return Token{}, err
+	}
+
+	text := string(l.input[start:l.pos])
+	value, err := numberValue(text)
+	if l.exact {
+		value, err = exactValue(text, value, err)
+	}
+	if err != nil {
+		return Token{}, &SyntaxError{
+			Column:  start + 1,
+			Token:   text,
+			Message: fmt.Sprintf("некорректное число %q: %v", text, err),
+		}
+	}
+	return Token{Kind: TokenNumber, Text: text, Column: start + 1, Value: value}, nil
+}
+
+// Десятичное число с необязательной дробной частью и порядком
+func (l *Lexer) readDecimal(start int) error {
+	if err := l.readDigits(start, isDecimalDigit); err != nil {
+		return err
+	}
+
+	if l.peek(0) == '.' {
+		l.pos++
+		if err := l.readDigits(start, isDecimalDigit); err != nil {
+			return err
+		}
+	}
+
+	if l.peek(0) == 'e' || l.peek(0) == 'E' {
+		l.pos++
+		if l.peek(0) == '+' || l.peek(0) == '-' {
+			l.pos++
+		}
+		if !isDecimalDigit(l.peek(0)) {
+			return l.numberError(start, "ожидались цифры порядка")
+		}
+		if err := l.readDigits(start, isDecimalDigit); err != nil {
+			return err
+		}
+	}
+
+	if l.peek(0) == '.' {
+		return l.numberError(start, "лишняя точка в числе")
+	}
+	return nil
+}
+
+// Целое число с префиксом 0x или 0b
+func (l *Lexer) readPrefixed(start int, isDigit func(rune) bool, kind string) error {
+	l.pos += 2
+	if !isDigit(l.peek(0)) {
+		return l.numberError(start, fmt.Sprintf("ожидались %sые цифры", kind))
+	}
+	if err := l.readDigits(start, isDigit); err != nil {
+		return err
+	}
+
+	if ch := l.peek(0); ch == '.' || unicode.IsLetter(ch) || unicode.IsDigit(ch) {
+		return l.numberError(start, fmt.Sprintf("недопустимый символ %q в %sом числе", ch, kind))
+	}
+	return nil
+}
+
+// Читаем цифры, разрешая одиночный "_" между ними
+func (l *Lexer) readDigits(start int, isDigit func(rune) bool) error {
+	for {
+		ch := l.peek(0)
+		if isDigit(ch) {
+			l.pos++
+			continue
+		}
+		if ch != '_' {
+			return nil
+		}
+		if l.pos == 0 || !isDigit(l.input[l.pos-1]) || !isDigit(l.peek(1)) {
+			return l.numberError(start, "разделитель \"_\" допускается только между цифрами")
+		}
+		l.pos++
+	}
+}
+
+// Ошибка в позиции текущего символа; токеном считаем всё слово целиком
+func (l *Lexer) numberError(start int, msg string) *SyntaxError {
+	end := l.pos
+	for end < len(l.input) {
+		ch := l.input[end]
+		if !(unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '.' || ch == '_') {
+			break
+		}
+		end++
+	}
+	return &SyntaxError{
+		Column:   l.pos + 1,
+		Token:    string(l.input[start:end]),
+		Expected: []string{TokenNumber.String()},
+		Message:  msg,
+	}
+}
+
+var errOutOfRange = errors.New("значение вне допустимого диапазона")
+
+// Предел порядка числа в десятичном режиме, как предел размера значения
+// у агента: 10000 десятичных знаков, в битах - 10000·log2(10)
+const maxExactBits = 10000 * 3322 / 1000
+
+// В десятичном режиме точное значение агент возьмёт из Literal, а float64 -
+// только приближение. Число больше float64 заменяем наибольшим конечным,
+// меньше - наименьшим положительным, чтобы при упрощении литерал не
+// принимался за 0 или 1
+func exactValue(text string, value float64, err error) (float64, error) {
+	if err != nil && !errors.Is(err, errOutOfRange) {
+		return 0, err
+	}
+	f, _, parseErr := big.ParseFloat(strings.ReplaceAll(text, "_", ""), 0, 64, big.ToNearestEven)
+	if parseErr != nil {
+		return 0, parseErr
+	}
+	if f.Sign() == 0 {
+		return 0, nil
+	}
+	if exp := f.MantExp(nil); exp > maxExactBits || exp < -maxExactBits {
+		return 0, errOutOfRange
+	}
+
+	switch {
+	case err != nil:
+		return math.MaxFloat64, nil
+	case value == 0:
+		return math.SmallestNonzeroFloat64, nil
+	}
+	return value, nil
+}
+
+// Точное значение литерала: десятичные числа округляются по IEEE 754,
+// целые с префиксом переводятся через big.Int без потери разрядов
+func numberValue(text string) (float64, error) {
+	clean := strings.ReplaceAll(text, "_", "")
+
+	if len(clean) > 1 && clean[0] == '0' && strings.ContainsAny(clean[1:2], "xXbB") {
+		n, ok := new(big.Int).SetString(clean, 0)
+		if !ok {
+			return 0, fmt.Errorf("не удалось разобрать")
+		}
+		value, _ := new(big.Float).SetInt(n).Float64()
+		if math.IsInf(value, 0) {
+			return 0, errOutOfRange
+		}
+		return value, nil
+	}
+
+	value, err := strconv.ParseFloat(clean, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return 0, errOutOfRange
+		}
+		return 0, err
+	}
+	return value, nil
+}
+
+// DecimalLiteral возвращает точную десятичную запись литерала без
+// разделителей, целые с префиксом 0x и 0b переводятся в десятичные
+func DecimalLiteral(text string) string {
+	clean := strings.ReplaceAll(text, "_", "")
+	if len(clean) > 1 && clean[0] == '0' && strings.ContainsAny(clean[1:2], "xXbB") {
+		if n, ok := new(big.Int).SetString(clean, 0); ok {
+			return n.String()
+		}
+	}
+	return clean
+}
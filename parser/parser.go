@@ -0,0 +1,324 @@
+package parser
+
+import (
+	"calc/models"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Допустимое число аргументов встроенных функций, maxArgs < 0 - без ограничения
+var functions = map[string]struct{ minArgs, maxArgs int }{
+	"sqrt":  {1, 1},
+	"abs":   {1, 1},
+	"min":   {1, -1},
+	"max":   {1, -1},
+	"round": {1, 2},
+	"log":   {1, 2},
+	"sin":   {1, 1},
+	"cos":   {1, 1},
+}
+
+// Список имён встроенных функций в алфавитном порядке
+func functionNames() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SyntaxError описывает место и причину ошибки в выражении
+type SyntaxError struct {
+	Column   int      `json:"column"`
+	Token    string   `json:"token"`
+	Expected []string `json:"expected,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("позиция %d: %s", e.Column, e.Message)
+	if len(e.Expected) > 0 {
+		msg += fmt.Sprintf(" (ожидалось: %s)", strings.Join(e.Expected, ", "))
+	}
+	return msg
+}
+
+// Parser - рекурсивный спуск по грамматике:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/" | "//" | "%") unary }
+//	unary   = ("-" | "+") unary | power
+//	power   = primary [ "^" unary ]
+//	primary = number | call | ident | "(" expr ")"
+//	call    = ident "(" expr { "," expr } ")"
+type Parser struct {
+	lexer *Lexer
+	tok   Token
+}
+
+// Parse разбирает выражение и возвращает корень AST
+func Parse(input string) (*models.ASTNode, error) {
+	return parse(NewLexer(input))
+}
+
+// ParseDecimal разбирает выражение для десятичного режима: числа вне
+// диапазона float64 допустимы, их точное значение хранится в Literal
+func ParseDecimal(input string) (*models.ASTNode, error) {
+	lexer := NewLexer(input)
+	lexer.exact = true
+	return parse(lexer)
+}
+
+func parse(lexer *Lexer) (*models.ASTNode, error) {
+	p := &Parser{lexer: lexer}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.Kind != TokenEOF {
+		return nil, p.unexpected("оператор", TokenEOF.String())
+	}
+	return node, nil
+}
+
+func (p *Parser) advance() error {
+	tok, err := p.lexer.Next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// Ошибка "неожиданный токен" для текущей позиции
+func (p *Parser) unexpected(expected ...string) *SyntaxError {
+	text := p.tok.Text
+	msg := fmt.Sprintf("неожиданный токен %q", text)
+	if p.tok.Kind == TokenEOF {
+		msg = "неожиданный конец выражения"
+	}
+	return &SyntaxError{
+		Column:   p.tok.Column,
+		Token:    text,
+		Expected: expected,
+		Message:  msg,
+	}
+}
+
+func (p *Parser) parseExpr() (*models.ASTNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.Kind == TokenPlus || p.tok.Kind == TokenMinus {
+		op := p.tok
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+	return left, nil
+}
+
+func (p *Parser) parseTerm() (*models.ASTNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for isMulOperator(p.tok.Kind) {
+		op := p.tok
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+	return left, nil
+}
+
+func isMulOperator(kind TokenKind) bool {
+	switch kind {
+	case TokenStar, TokenSlash, TokenDoubleSlash, TokenPercent:
+		return true
+	}
+	return false
+}
+
+// Унарные операторы связывают слабее степени: -2^2 = -(2^2)
+func (p *Parser) parseUnary() (*models.ASTNode, error) {
+	if p.tok.Kind != TokenMinus && p.tok.Kind != TokenPlus {
+		return p.parsePower()
+	}
+
+	op := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &models.ASTNode{
+		Operator: "u" + op.Text,
+		Left:     operand,
+		Column:   op.Column,
+	}, nil
+}
+
+// Возведение в степень правоассоциативно: 2^3^2 = 2^(3^2)
+func (p *Parser) parsePower() (*models.ASTNode, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.Kind != TokenCaret {
+		return base, nil
+	}
+	op := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return binary(op, base, exponent), nil
+}
+
+func (p *Parser) parsePrimary() (*models.ASTNode, error) {
+	switch p.tok.Kind {
+	case TokenNumber:
+		return p.number()
+
+	case TokenLParen:
+		open := p.tok
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.Kind != TokenRParen {
+			err := p.unexpected("оператор", TokenRParen.String())
+			if p.tok.Kind == TokenEOF {
+				err.Message = fmt.Sprintf("не закрыта скобка из позиции %d", open.Column)
+			}
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return node, nil
+
+	case TokenIdent:
+		return p.parseIdent()
+
+	default:
+		return nil, p.unexpected(TokenNumber.String(), TokenLParen.String(), TokenMinus.String(), TokenPlus.String(), TokenIdent.String())
+	}
+}
+
+// Идентификатор со скобкой - вызов функции, без скобки - переменная
+func (p *Parser) parseIdent() (*models.ASTNode, error) {
+	name := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.Kind != TokenLParen {
+		return &models.ASTNode{
+			Variable: name.Text,
+			IsLeaf:   true,
+			Column:   name.Column,
+		}, nil
+	}
+	return p.parseCall(name)
+}
+
+func (p *Parser) parseCall(name Token) (*models.ASTNode, error) {
+	arity, ok := functions[name.Text]
+	if !ok {
+		return nil, &SyntaxError{
+			Column:   name.Column,
+			Token:    name.Text,
+			Expected: functionNames(),
+			Message:  fmt.Sprintf("неизвестная функция %q", name.Text),
+		}
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	node := &models.ASTNode{Operator: name.Text, Column: name.Column}
+	for p.tok.Kind != TokenRParen || len(node.Args) > 0 {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		node.Args = append(node.Args, arg)
+
+		if p.tok.Kind == TokenRParen {
+			break
+		}
+		if p.tok.Kind != TokenComma {
+			return nil, p.unexpected("оператор", TokenComma.String(), TokenRParen.String())
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	count := len(node.Args)
+	if count < arity.minArgs || (arity.maxArgs >= 0 && count > arity.maxArgs) {
+		return nil, &SyntaxError{
+			Column:  name.Column,
+			Token:   name.Text,
+			Message: fmt.Sprintf("функция %s: неверное число аргументов (%d)", name.Text, count),
+		}
+	}
+	return node, nil
+}
+
+func (p *Parser) number() (*models.ASTNode, error) {
+	tok := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return &models.ASTNode{
+		Value:   tok.Value,
+		Literal: tok.Text,
+		IsLeaf:  true,
+		Column:  tok.Column,
+	}, nil
+}
+
+func binary(op Token, left, right *models.ASTNode) *models.ASTNode {
+	return &models.ASTNode{
+		Operator: op.Text,
+		Left:     left,
+		Right:    right,
+		Column:   op.Column,
+	}
+}
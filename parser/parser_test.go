@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"calc/models"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Дерево со всеми скобками: (1+(2*3)), u-(5), max(1,2)
+func formatTree(node *models.ASTNode) string {
+	if node.IsLeaf {
+		if node.Variable != "" {
+			return node.Variable
+		}
+		return strconv.FormatFloat(node.Value, 'g', -1, 64)
+	}
+	if node.Args != nil {
+		args := make([]string, len(node.Args))
+		for i, arg := range node.Args {
+			args[i] = formatTree(arg)
+		}
+		return node.Operator + "(" + strings.Join(args, ",") + ")"
+	}
+	if node.Right == nil {
+		return node.Operator + "(" + formatTree(node.Left) + ")"
+	}
+	return "(" + formatTree(node.Left) + node.Operator + formatTree(node.Right) + ")"
+}
+
+func TestParseTree(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1+2*3", "(1+(2*3))"},
+		{"1-2-3", "((1-2)-3)"},
+		{"-2^2", "u-((2^2))"},
+		{"2^3^2", "(2^(3^2))"},
+		{"2^-1", "(2^u-(1))"},
+		{"--5", "u-(u-(5))"},
+		{"+-5", "u+(u-(5))"},
+		{"7//2%3", "((7//2)%3)"},
+		{"max(1, 2+3, x)", "max(1,(2+3),x)"},
+		{"round(-x, 2)", "round(u-(x),2)"},
+		{"0xFF + 0b101 + 1_000 + .5 + 1e-3", "((((255+5)+1000)+0.5)+0.001)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tree, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatTree(tree); got != tt.want {
+				t.Errorf("%s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	operand := []string{TokenNumber.String(), TokenLParen.String(), TokenMinus.String(), TokenPlus.String(), TokenIdent.String()}
+
+	tests := []struct {
+		input    string
+		column   int
+		token    string
+		expected []string
+	}{
+		// Некорректные числа
+		{"1.2.3", 4, "1.2.3", []string{TokenNumber.String()}},
+		{"0b102", 5, "0b102", []string{TokenNumber.String()}},
+		{"1__0", 2, "1__0", []string{TokenNumber.String()}},
+		{"1_", 2, "1_", []string{TokenNumber.String()}},
+		{"0x", 3, "0x", []string{TokenNumber.String()}},
+		{"1e+", 4, "1e+", []string{TokenNumber.String()}},
+		{"1 + 1e400", 5, "1e400", nil},
+		// Неожиданные токены
+		{"min(1,)", 7, ")", operand},
+		{"(", 2, "", operand},
+		{"1+", 3, "", operand},
+		{")", 1, ")", operand},
+		{"1 + * 2", 5, "*", operand},
+		{"2 3", 3, "3", []string{"оператор", TokenEOF.String()}},
+		// Функции
+		{"foo(1)", 1, "foo", functionNames()},
+		{"sqrt(1, 2)", 1, "sqrt", nil},
+		{"1 + max()", 5, "max", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("ошибка %v, ожидалась SyntaxError", err)
+			}
+			if syntaxErr.Column != tt.column || syntaxErr.Token != tt.token {
+				t.Errorf("позиция %d, токен %q; ожидалось %d, %q", syntaxErr.Column, syntaxErr.Token, tt.column, tt.token)
+			}
+			if !reflect.DeepEqual(syntaxErr.Expected, tt.expected) {
+				t.Errorf("ожидалось %q, в ошибке %q", tt.expected, syntaxErr.Expected)
+			}
+		})
+	}
+}
+
+// В десятичном режиме числа вне диапазона float64 допустимы, точное
+// значение остаётся в Literal
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		input   string
+		value   float64
+		literal string
+	}{
+		{"1e400", math.MaxFloat64, "1e400"},
+		{"1e-400", math.SmallestNonzeroFloat64, "1e-400"},
+		{"0.0e-400", 0, "0.0e-400"},
+		{"2.5", 2.5, "2.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tree, err := ParseDecimal(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tree.Value != tt.value || tree.Literal != tt.literal {
+				t.Errorf("значение %v, запись %q; ожидалось %v, %q", tree.Value, tree.Literal, tt.value, tt.literal)
+			}
+		})
+	}
+
+	var syntaxErr *SyntaxError
+	if _, err := ParseDecimal("1 + 1e20000"); !errors.As(err, &syntaxErr) || syntaxErr.Column != 5 {
+		t.Errorf("1e20000: ошибка %v, ожидалась ошибка в позиции 5", err)
+	}
+}
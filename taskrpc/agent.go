@@ -0,0 +1,146 @@
+package taskrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"calc/models"
+
+	"google.golang.org/grpc"
+)
+
+// Интервал, с которым агент сообщает оркестратору, что он на связи
+const heartbeatInterval = 5 * time.Second
+
+// Запускаем агента поверх gRPC-потока: reg.ComputingPower воркеров
+// считают задачи функцией perform. Возвращает ошибку, когда поток
+// закрывается; ID, выданный оркестратором, записывается в reg.Id,
+// чтобы при переподключении агент зарегистрировался с тем же ID
+func RunAgent(ctx context.Context, conn grpc.ClientConnInterface, reg *models.AgentRegistration,
+	perform func(*models.Task) models.Responce2) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := Connect(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if err := stream.Send(&AgentMessage{Register: reg}); err != nil {
+		return err
+	}
+	reply, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if reply.AgentID == "" {
+		return errors.New("оркестратор не выдал ID агента")
+	}
+	reg.Id = reply.AgentID
+
+	workers := max(reg.ComputingPower, 1)
+
+	// gRPC не разрешает отправлять в поток из нескольких горутин одновременно
+	var sendMu sync.Mutex
+	send := func(m *AgentMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(m)
+	}
+
+	// Закрытие канала останавливает продление аренды задачи. stopLease
+	// возвращает false, если оркестратор уже отменил задачу
+	var leasesMu sync.Mutex
+	leases := make(map[string]chan struct{})
+	stopLease := func(id string) bool {
+		leasesMu.Lock()
+		defer leasesMu.Unlock()
+		stop, ok := leases[id]
+		if ok {
+			close(stop)
+			delete(leases, id)
+		}
+		return ok
+	}
+
+	tasks := make(chan *models.Task, workers)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(tasks)
+		for {
+			m, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			if m.Drop != "" {
+				stopLease(m.Drop)
+			}
+			if m.Task != nil {
+				tasks <- m.Task
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				stop := make(chan struct{})
+				leasesMu.Lock()
+				leases[task.Id] = stop
+				leasesMu.Unlock()
+				go keepLease(task, stop, send)
+
+				result := perform(task)
+
+				// Вместе с результатом просим следующую задачу; результат
+				// отменённой задачи оркестратору не нужен
+				m := &AgentMessage{Request: 1}
+				if stopLease(task.Id) {
+					m.Result = &result
+				}
+				if send(m) != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	if err := send(&AgentMessage{Request: workers}); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			send(&AgentMessage{Heartbeat: true})
+		case err := <-recvErr:
+			cancel()
+			wg.Wait()
+			return err
+		}
+	}
+}
+
+// Продлеваем аренду задачи каждую треть её срока, пока не закрыт stop
+func keepLease(task *models.Task, stop <-chan struct{}, send func(*AgentMessage) error) {
+	interval := max(time.Until(task.LeaseDeadline)/3, 50*time.Millisecond)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			send(&AgentMessage{Lease: task.Id})
+		}
+	}
+}
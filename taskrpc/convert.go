@@ -0,0 +1,216 @@
+package taskrpc
+
+import (
+	"time"
+
+	"calc/models"
+	"calc/taskrpc/taskpb"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Перевод сообщений между models и protobuf. Пустое время передаётся
+// как отсутствующий Timestamp, nil-поля остаются nil
+
+func agentMessageToProto(m *AgentMessage) *taskpb.AgentMessage {
+	return &taskpb.AgentMessage{
+		Register:  registrationToProto(m.Register),
+		Result:    resultToProto(m.Result),
+		Request:   int32(m.Request),
+		Lease:     m.Lease,
+		Heartbeat: m.Heartbeat,
+	}
+}
+
+func agentMessageFromProto(m *taskpb.AgentMessage) *AgentMessage {
+	return &AgentMessage{
+		Register:  registrationFromProto(m.GetRegister()),
+		Result:    resultFromProto(m.GetResult()),
+		Request:   int(m.GetRequest()),
+		Lease:     m.GetLease(),
+		Heartbeat: m.GetHeartbeat(),
+	}
+}
+
+func serverMessageToProto(m *ServerMessage) *taskpb.ServerMessage {
+	pb := &taskpb.ServerMessage{
+		AgentId: m.AgentID,
+		Task:    taskToProto(m.Task),
+		Drop:    m.Drop,
+	}
+	if m.Lease != nil {
+		pb.Lease = &taskpb.LeaseResponse{
+			Id:            m.Lease.Id,
+			LeaseDeadline: timestampToProto(m.Lease.LeaseDeadline),
+		}
+	}
+	return pb
+}
+
+func serverMessageFromProto(m *taskpb.ServerMessage) *ServerMessage {
+	msg := &ServerMessage{
+		AgentID: m.GetAgentId(),
+		Task:    taskFromProto(m.GetTask()),
+		Drop:    m.GetDrop(),
+	}
+	if lease := m.GetLease(); lease != nil {
+		msg.Lease = &models.LeaseResponse{
+			Id:            lease.GetId(),
+			LeaseDeadline: timestampFromProto(lease.GetLeaseDeadline()),
+		}
+	}
+	return msg
+}
+
+func registrationToProto(reg *models.AgentRegistration) *taskpb.AgentRegistration {
+	if reg == nil {
+		return nil
+	}
+	return &taskpb.AgentRegistration{
+		Id:             reg.Id,
+		ComputingPower: int32(reg.ComputingPower),
+		Version:        reg.Version,
+		Operations:     reg.Operations,
+	}
+}
+
+func registrationFromProto(reg *taskpb.AgentRegistration) *models.AgentRegistration {
+	if reg == nil {
+		return nil
+	}
+	return &models.AgentRegistration{
+		Id:             reg.GetId(),
+		ComputingPower: int(reg.GetComputingPower()),
+		Version:        reg.GetVersion(),
+		Operations:     reg.GetOperations(),
+	}
+}
+
+func resultToProto(result *models.Responce2) *taskpb.TaskResult {
+	if result == nil {
+		return nil
+	}
+	return &taskpb.TaskResult{
+		Id:         result.Id,
+		Result:     result.Result,
+		ResultText: result.ResultText,
+		ErrorCode:  result.ErrorCode,
+		Error:      result.Error,
+	}
+}
+
+func resultFromProto(result *taskpb.TaskResult) *models.Responce2 {
+	if result == nil {
+		return nil
+	}
+	return &models.Responce2{
+		Id:         result.GetId(),
+		Result:     result.GetResult(),
+		ResultText: result.GetResultText(),
+		ErrorCode:  result.GetErrorCode(),
+		Error:      result.GetError(),
+	}
+}
+
+// Задача в виде сообщения protobuf. UserID не передаётся агенту,
+// как и в JSON-ответе /internal/task
+func taskToProto(task *models.Task) *taskpb.Task {
+	if task == nil {
+		return nil
+	}
+	pb := &taskpb.Task{
+		Id:              task.Id,
+		Operation:       task.Operation,
+		Result:          task.Result,
+		OperationTimeMs: task.Operation_time_ms,
+		Dependencies:    task.Dependencies,
+		Status:          task.Status,
+		ExpressionId:    task.ExpressionID,
+		IsFinal:         task.IsFinal,
+		DecimalResult:   task.DecimalResult,
+		ErrorCode:       task.ErrorCode,
+		Error:           task.Error,
+		Attempts:        int32(task.Attempts),
+		LeaseDeadline:   timestampToProto(task.LeaseDeadline),
+		AgentId:         task.AgentID,
+		Priority:        int32(task.Priority),
+		Deadline:        timestampToProto(task.Deadline),
+		CriticalPathMs:  task.CriticalPathMs,
+		CreatedAt:       timestampToProto(task.CreatedAt),
+		StartedAt:       timestampToProto(task.StartedAt),
+		FinishedAt:      timestampToProto(task.FinishedAt),
+	}
+	for _, operand := range task.Operands {
+		pb.Operands = append(pb.Operands, &taskpb.Operand{
+			Value:    operand.Value,
+			Text:     operand.Text,
+			Ref:      operand.Ref,
+			Resolved: operand.Resolved,
+		})
+	}
+	if task.Decimal != nil {
+		pb.Decimal = &taskpb.Precision{
+			Scale:    int32(task.Decimal.Scale),
+			Rounding: task.Decimal.Rounding,
+		}
+	}
+	return pb
+}
+
+// Задача из сообщения protobuf
+func taskFromProto(pb *taskpb.Task) *models.Task {
+	if pb == nil {
+		return nil
+	}
+	task := &models.Task{
+		Id:                pb.GetId(),
+		Operation:         pb.GetOperation(),
+		Result:            pb.GetResult(),
+		Operation_time_ms: pb.GetOperationTimeMs(),
+		Dependencies:      pb.GetDependencies(),
+		Status:            pb.GetStatus(),
+		ExpressionID:      pb.GetExpressionId(),
+		IsFinal:           pb.GetIsFinal(),
+		DecimalResult:     pb.GetDecimalResult(),
+		ErrorCode:         pb.GetErrorCode(),
+		Error:             pb.GetError(),
+		Attempts:          int(pb.GetAttempts()),
+		LeaseDeadline:     timestampFromProto(pb.GetLeaseDeadline()),
+		AgentID:           pb.GetAgentId(),
+		Priority:          int(pb.GetPriority()),
+		Deadline:          timestampFromProto(pb.GetDeadline()),
+		CriticalPathMs:    pb.GetCriticalPathMs(),
+		CreatedAt:         timestampFromProto(pb.GetCreatedAt()),
+		StartedAt:         timestampFromProto(pb.GetStartedAt()),
+		FinishedAt:        timestampFromProto(pb.GetFinishedAt()),
+	}
+	for _, operand := range pb.GetOperands() {
+		task.Operands = append(task.Operands, models.Operand{
+			Value:    operand.GetValue(),
+			Text:     operand.GetText(),
+			Ref:      operand.GetRef(),
+			Resolved: operand.GetResolved(),
+		})
+	}
+	if decimal := pb.GetDecimal(); decimal != nil {
+		task.Decimal = &models.Precision{
+			Scale:    int(decimal.GetScale()),
+			Rounding: decimal.GetRounding(),
+		}
+	}
+	return task
+}
+
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timestampFromProto(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
@@ -0,0 +1,68 @@
+package taskrpc
+
+import (
+	"calc/models"
+	"calc/taskrpc/taskpb"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Сообщения проходят через protobuf без потерь, кроме UserID,
+// который агенту не передаётся
+func TestConvertRoundTrip(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	task := &models.Task{
+		Id:                "t1",
+		Operands:          []models.Operand{{Value: 1.5, Text: "1.5"}, {Value: 2, Ref: "t0", Resolved: true}},
+		Operation:         "+",
+		Operation_time_ms: 100,
+		Dependencies:      []string{"t0"},
+		Status:            models.TaskRunning,
+		ExpressionID:      "e1",
+		IsFinal:           true,
+		Decimal:           &models.Precision{Scale: 2, Rounding: "half_up"},
+		Attempts:          1,
+		LeaseDeadline:     now.Add(time.Minute),
+		AgentID:           "a1",
+		Priority:          3,
+		CriticalPathMs:    250,
+		CreatedAt:         now,
+		StartedAt:         now.Add(time.Second),
+		UserID:            "u1",
+	}
+
+	var pb taskpb.ServerMessage
+	data, err := proto.Marshal(serverMessageToProto(&ServerMessage{
+		Task:  task,
+		Lease: &models.LeaseResponse{Id: "t1", LeaseDeadline: now},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		t.Fatal(err)
+	}
+	got := serverMessageFromProto(&pb)
+
+	want := *task
+	want.UserID = ""
+	if !reflect.DeepEqual(got.Task, &want) {
+		t.Errorf("задача %+v, ожидалось %+v", got.Task, want)
+	}
+	if got.Lease == nil || got.Lease.Id != "t1" || !got.Lease.LeaseDeadline.Equal(now) {
+		t.Errorf("аренда %+v", got.Lease)
+	}
+
+	message := &AgentMessage{
+		Register: &models.AgentRegistration{Id: "a1", ComputingPower: 4, Version: "1", Operations: []string{"+", "sqrt"}},
+		Result:   &models.Responce2{Id: "t1", Result: 3.5, ResultText: "3.50"},
+		Request:  2,
+		Lease:    "t1",
+	}
+	if back := agentMessageFromProto(agentMessageToProto(message)); !reflect.DeepEqual(back, message) {
+		t.Errorf("сообщение агента %+v, ожидалось %+v", back, message)
+	}
+}
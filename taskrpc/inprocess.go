@@ -0,0 +1,39 @@
+package taskrpc
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Запускаем сервер и клиент в одном процессе без сети: соединение идёт
+// через буфер в памяти. Нужен, чтобы проверять обе стороны транспорта
+// вместе, например оркестратор и RunAgent. stop останавливает сервер
+// и закрывает соединение
+func InProcess(srv TaskServiceServer) (conn *grpc.ClientConn, stop func(), err error) {
+	listener := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer()
+	Register(server, srv)
+	go server.Serve(listener)
+
+	conn, err = grpc.NewClient("passthrough:///inprocess",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		server.Stop()
+		return nil, nil, err
+	}
+
+	stop = func() {
+		conn.Close()
+		server.Stop()
+	}
+	return conn, stop, nil
+}
@@ -0,0 +1,119 @@
+package taskrpc_test
+
+import (
+	"calc/database"
+	"calc/models"
+	"calc/operations"
+	"calc/orchestrator"
+	"calc/taskrpc"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Оркестратор и агент в одном процессе: выражения отправляются через
+// /api/v1/calculate, задачи идут агенту через gRPC-поток в памяти и
+// считаются кодом агента из пакета operations
+func TestInProcess(t *testing.T) {
+	// Базы SQLite создаются в текущем каталоге
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	_, expressionDB, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskDB, err := database.InitTaskDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orchestrator.InitTaskStore(taskDB, expressionDB); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, stop, err := taskrpc.InProcess(&orchestrator.TaskServer{DB: expressionDB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg := &models.AgentRegistration{ComputingPower: 2, Operations: operations.Supported}
+	go taskrpc.RunAgent(ctx, conn, reg, operations.Perform)
+
+	const userID = "user"
+	token, err := orchestrator.GenerateJWT(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expression string
+		status     string
+		result     float64
+		errorCode  string
+	}{
+		{"(1+2)*(3+4)-5/2", models.StatusDone, 18.5, ""},
+		{"sqrt(16)+max(1,2,3)", models.StatusDone, 7, ""},
+		{"7//2 + 2^10 - 7%2", models.StatusDone, 1026, ""},
+		{"round(log(8, 2) + sin(0), 1)", models.StatusDone, 3, ""},
+		{"1/0+2", models.StatusError, 0, models.ErrDivisionByZero},
+		{"sqrt(1-2)", models.StatusError, 0, models.ErrDomain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			// Без оптимизации, чтобы выражение считал агент, а не оркестратор
+			body := `{"expression": "` + tt.expression + `", "optimize": false}`
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			orchestrator.CalculateHandler(rec, req, expressionDB)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("статус %d: %s", rec.Code, rec.Body)
+			}
+			var created models.Responce1
+			if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+
+			expr := waitExpression(t, expressionDB, created.Id, userID)
+			if expr.Status != tt.status || expr.Result != tt.result || expr.ErrorCode != tt.errorCode {
+				t.Errorf("статус %q, результат %v, ошибка %q; ожидалось %q, %v, %q",
+					expr.Status, expr.Result, expr.ErrorCode, tt.status, tt.result, tt.errorCode)
+			}
+		})
+	}
+}
+
+// Ждём итоговый статус выражения
+func waitExpression(t *testing.T, db *sql.DB, id string, userID string) *models.Expression {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		expr, err := database.GetExpressionByID(db, id, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expr != nil && expr.Status != models.StatusPending && expr.Status != models.StatusRunning {
+			return expr
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("выражение %s не посчитано за 10 секунд", id)
+	return nil
+}
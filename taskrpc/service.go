@@ -0,0 +1,110 @@
+// Пакет taskrpc - gRPC-транспорт между оркестратором и агентом.
+// Один двунаправленный поток заменяет эндпоинты /internal/task:
+// агент регистрируется, запрашивает задачи, продлевает аренду
+// и отправляет результаты, а оркестратор присылает задачи по мере появления.
+// Сервис calc.TaskService описан в taskpb/task.proto; остальной код
+// работает со структурами models, перевод в protobuf - в convert.go
+package taskrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative taskpb/task.proto
+
+import (
+	"context"
+
+	"calc/models"
+	"calc/taskrpc/taskpb"
+
+	"google.golang.org/grpc"
+)
+
+// Сообщение агента. В одном сообщении может быть несколько полей,
+// оркестратор обрабатывает их в порядке объявления
+type AgentMessage struct {
+	Register  *models.AgentRegistration // первое сообщение потока
+	Result    *models.Responce2         // результат задачи
+	Request   int                       // сколько ещё задач агент готов взять
+	Lease     string                    // продлить аренду задачи с этим ID
+	Heartbeat bool                      // агент на связи
+}
+
+// Сообщение оркестратора
+type ServerMessage struct {
+	AgentID string // ответ на регистрацию
+	Task    *models.Task
+	Lease   *models.LeaseResponse
+	Drop    string // задача больше не ждёт результата от агента
+}
+
+// Серверная часть сервиса, её реализует оркестратор
+type TaskServiceServer interface {
+	Connect(ConnectServer) error
+}
+
+type ConnectServer interface {
+	Send(*ServerMessage) error
+	Recv() (*AgentMessage, error)
+	grpc.ServerStream
+}
+
+type ConnectClient interface {
+	Send(*AgentMessage) error
+	Recv() (*ServerMessage, error)
+	grpc.ClientStream
+}
+
+// Регистрируем реализацию сервиса на gRPC-сервере
+func Register(s grpc.ServiceRegistrar, srv TaskServiceServer) {
+	taskpb.RegisterTaskServiceServer(s, &server{srv: srv})
+}
+
+// Открываем поток Connect
+func Connect(ctx context.Context, conn grpc.ClientConnInterface) (ConnectClient, error) {
+	stream, err := taskpb.NewTaskServiceClient(conn).Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &connectClient{stream}, nil
+}
+
+// Сгенерированный сервер: передаёт поток реализации, переводя сообщения
+// из protobuf в models и обратно
+type server struct {
+	taskpb.UnimplementedTaskServiceServer
+	srv TaskServiceServer
+}
+
+func (s *server) Connect(stream taskpb.TaskService_ConnectServer) error {
+	return s.srv.Connect(&connectServer{stream})
+}
+
+type connectServer struct {
+	taskpb.TaskService_ConnectServer
+}
+
+func (s *connectServer) Send(m *ServerMessage) error {
+	return s.TaskService_ConnectServer.Send(serverMessageToProto(m))
+}
+
+func (s *connectServer) Recv() (*AgentMessage, error) {
+	m, err := s.TaskService_ConnectServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return agentMessageFromProto(m), nil
+}
+
+type connectClient struct {
+	taskpb.TaskService_ConnectClient
+}
+
+func (c *connectClient) Send(m *AgentMessage) error {
+	return c.TaskService_ConnectClient.Send(agentMessageToProto(m))
+}
+
+func (c *connectClient) Recv() (*ServerMessage, error) {
+	m, err := c.TaskService_ConnectClient.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return serverMessageFromProto(m), nil
+}